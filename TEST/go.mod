@@ -3,8 +3,23 @@ module myproject
 go 1.23.3
 
 require (
-	github.com/PuerkitoBio/goquery v1.10.0 // indirect
+	crawlkit v0.0.0
+	github.com/PuerkitoBio/goquery v1.10.0
+	golang.org/x/text v0.20.0
+)
+
+require (
 	github.com/andybalholm/cascadia v1.3.2 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
 	golang.org/x/net v0.29.0 // indirect
-	golang.org/x/text v0.20.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	modernc.org/libc v1.65.10 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+	modernc.org/sqlite v1.38.0 // indirect
 )
+
+replace crawlkit => ../crawlkit
@@ -0,0 +1,220 @@
+// Package block recognises captcha, WAF and rate-limit pages served in place
+// of content and pauses the crawl. The error it returns lets the crawlers
+// dead-letter the URL for a later retry.
+package block
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBlocked is returned by Detector.Check for a block page
+var ErrBlocked = errors.New("blocked")
+
+// TitleMarkers are lower-case snippets of the <title> of the block pages the
+// sites and their WAFs serve. Only the title is matched, as a normal page may
+// well mention a captcha or load a script from a CDN in its body.
+var TitleMarkers = []string{
+	"captcha", "access denied", "request rejected", "too many requests",
+	"attention required", "just a moment", "security check",
+}
+
+// BodyMarkers are lower-case snippets only a block page carries: the
+// challenge scripts and incident IDs the WAFs inject and the F5 rejection
+// text. They are matched against the raw body, so only ASCII ones are listed
+// (the SMCE pages are TIS-620).
+var BodyMarkers = []string{
+	"/cdn-cgi/challenge-platform/", "cf-chl-", "_incapsula_resource",
+	"incapsula incident id", "the requested url was rejected",
+}
+
+// Detect looks for block markers and block status codes
+func Detect(status int, body []byte) (bool, string) {
+	lower := strings.ToLower(string(body))
+	t := title(lower)
+	for _, marker := range TitleMarkers {
+		if strings.Contains(t, marker) {
+			return true, fmt.Sprintf("block page title %q", marker)
+		}
+	}
+	for _, marker := range BodyMarkers {
+		if strings.Contains(lower, marker) {
+			return true, fmt.Sprintf("block marker %q", marker)
+		}
+	}
+	if status == http.StatusForbidden || status == http.StatusTooManyRequests {
+		return true, fmt.Sprintf("HTTP %d", status)
+	}
+	return false, ""
+}
+
+// title returns the text of the first <title> element of a lower-cased page
+func title(lower string) string {
+	start := strings.Index(lower, "<title")
+	if start < 0 {
+		return ""
+	}
+	open := strings.IndexByte(lower[start:], '>')
+	if open < 0 {
+		return ""
+	}
+	text := lower[start+open+1:]
+	if end := strings.Index(text, "</title"); end >= 0 {
+		text = text[:end]
+	}
+	return strings.TrimSpace(text)
+}
+
+// Options are the block detection flags shared by the crawlers
+type Options struct {
+	Cooldown    time.Duration
+	MaxCooldown time.Duration
+}
+
+// AddFlags registers --block-cooldown and --block-max-cooldown
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&o.Cooldown, "block-cooldown", 30*time.Second, "pause after the first block page, doubled for each one in a row")
+	fs.DurationVar(&o.MaxCooldown, "block-max-cooldown", 30*time.Minute, "longest pause after repeated block pages")
+}
+
+// Detector checks fetched pages. Besides markers it flags, through
+// Unexpected, a 200 page that fails the caller's structural checks and is far
+// smaller than what the same host and path usually return. The size alone is
+// no sign of a block: not-found popups and empty last pages are small too.
+// Its methods are no-ops on a nil Detector.
+type Detector struct {
+	Options
+	MinSamples int     // pages seen before the size check kicks in
+	MinRatio   float64 // smaller than this share of the median is suspicious
+	Logf       func(format string, args ...any)
+	Sleep      func(time.Duration)
+
+	mu     sync.Mutex
+	sizes  map[string][]int
+	streak int
+}
+
+// NewDetector returns a Detector with the default size check
+func NewDetector(opts Options) *Detector {
+	return &Detector{
+		Options:    opts,
+		MinSamples: 5,
+		MinRatio:   0.2,
+		Logf:       log.Printf,
+		Sleep:      time.Sleep,
+		sizes:      map[string][]int{},
+	}
+}
+
+// Check returns an ErrBlocked error for a block page, after pausing. Any
+// other page resets the cooldown, and a 200 one counts towards the usual
+// size of its host and path.
+func (d *Detector) Check(rawURL string, status int, body []byte) error {
+	if d == nil {
+		return nil
+	}
+	key := sizeKey(rawURL)
+
+	blocked, reason := Detect(status, body)
+	if !blocked {
+		d.mu.Lock()
+		d.streak = 0
+		if status == http.StatusOK {
+			d.sizes[key] = append(d.sizes[key], len(body))
+			if n := len(d.sizes[key]); n > 50 {
+				d.sizes[key] = d.sizes[key][n-50:]
+			}
+		}
+		d.mu.Unlock()
+		return nil
+	}
+
+	d.pause(reason)
+	return fmt.Errorf("%w: %s", ErrBlocked, reason)
+}
+
+// ReadBody reads a response body (up to 10 MB) and checks it
+func (d *Detector) ReadBody(rawURL string, resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	return body, d.Check(rawURL, resp.StatusCode, body)
+}
+
+// Unexpected is for a 200 page that fails the caller's structural checks
+// without being one of the site's known not-found or empty pages. If it is
+// far smaller than usual it is taken for a block page: Unexpected pauses and
+// returns an ErrBlocked error. Otherwise it returns nil and the caller
+// reports the page as malformed.
+func (d *Detector) Unexpected(rawURL string, size int) error {
+	if d == nil {
+		return nil
+	}
+	blocked, reason := d.unusualSize(sizeKey(rawURL), size)
+	if !blocked {
+		return nil
+	}
+	d.pause(reason)
+	return fmt.Errorf("%w: %s", ErrBlocked, reason)
+}
+
+// Blocked pauses for a block page recognised by the caller
+func (d *Detector) Blocked(reason string) {
+	if d == nil {
+		return
+	}
+	d.pause(reason)
+}
+
+func (d *Detector) unusualSize(key string, size int) (bool, string) {
+	d.mu.Lock()
+	sizes := append([]int(nil), d.sizes[key]...)
+	d.mu.Unlock()
+
+	if len(sizes) < d.MinSamples {
+		return false, ""
+	}
+	sort.Ints(sizes)
+	median := sizes[len(sizes)/2]
+	if float64(size) < float64(median)*d.MinRatio {
+		return true, fmt.Sprintf("unusually small page (%d bytes, typical %d)", size, median)
+	}
+	return false, ""
+}
+
+// pause sleeps for the cooldown, doubling it for each block in a row
+func (d *Detector) pause(reason string) {
+	d.mu.Lock()
+	d.streak++
+	cooldown := d.Cooldown
+	for i := 1; i < d.streak && cooldown < d.MaxCooldown; i++ {
+		cooldown *= 2
+	}
+	if d.MaxCooldown > 0 {
+		cooldown = min(cooldown, d.MaxCooldown)
+	}
+	streak := d.streak
+	d.mu.Unlock()
+
+	d.Logf("Blocked (%s), %d in a row, pausing for %s", reason, streak, cooldown)
+	d.Sleep(cooldown)
+}
+
+// sizeKey groups pages of the same kind: host and path without the query
+func sizeKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host + u.Path
+}
@@ -0,0 +1,177 @@
+// Package deadletter keeps every URL a crawl failed to fetch, with the error
+// class, the attempt count and the time, so that a retry-failed run can
+// replay them later and merge what it recovers into the existing output.
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"crawlkit/fetcherr"
+)
+
+// Entry is one line of the dead-letter file
+type Entry struct {
+	URL      string    `json:"url"`
+	Class    string    `json:"class"` // see fetcherr.Class
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// NewEntry describes a failure of rawURL that happened just now
+func NewEntry(rawURL string, err error, attempts int) Entry {
+	return Entry{URL: rawURL, Class: fetcherr.Class(err), Error: err.Error(), Attempts: attempts, FailedAt: time.Now()}
+}
+
+// Options are the dead-letter flags shared by the crawlers
+type Options struct {
+	File string
+}
+
+// AddFlags registers --dead-letter
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.File, "dead-letter", "failed.jsonl", "append failed URLs here; replay them with retry-failed")
+}
+
+// Queue appends failed URLs to the dead-letter file. Its methods are no-ops
+// on a nil Queue.
+type Queue struct {
+	File string
+	Logf func(format string, args ...any)
+
+	mu     sync.Mutex
+	count  int // URLs written to File
+	failed int // URLs added, written or not
+}
+
+// New returns a Queue writing to the --dead-letter file
+func New(opts Options) *Queue {
+	return &Queue{File: opts.File, Logf: log.Printf}
+}
+
+// Add records that rawURL failed with err after attempts tries
+func (q *Queue) Add(rawURL string, err error, attempts int) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failed++
+	if q.File == "" {
+		return
+	}
+	entry := NewEntry(rawURL, err, attempts)
+	file, openErr := os.OpenFile(q.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if openErr != nil {
+		q.Logf("Could not record failed URL %s: %v", rawURL, openErr)
+		return
+	}
+	defer file.Close()
+	if err := json.NewEncoder(file).Encode(entry); err != nil {
+		q.Logf("Could not record failed URL %s: %v", rawURL, err)
+		return
+	}
+	q.count++
+}
+
+// Failed returns how many URLs failed in this run, whether or not they went
+// to the dead-letter file
+func (q *Queue) Failed() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.failed
+}
+
+// LogReport logs how many URLs went to the dead-letter file
+func (q *Queue) LogReport(logf func(format string, args ...any)) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.count > 0 {
+		logf("%d failed URLs added to %s, replay them with retry-failed", q.count, q.File)
+	}
+}
+
+// Load reads a dead-letter file. A URL that failed in several runs keeps
+// only its latest entry, in the position it first appeared.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	index := map[string]int{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	for decoder.More() {
+		var e Entry
+		if err := decoder.Decode(&e); err != nil {
+			return entries, fmt.Errorf("%s: %v", path, err)
+		}
+		if i, ok := index[e.URL]; ok {
+			entries[i] = e
+			continue
+		}
+		index[e.URL] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Save replaces the dead-letter file with entries. An empty list leaves an
+// empty file.
+func Save(path string, entries []Entry) error {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Retry replays each entry through fetch. It returns how many recovered and
+// the entries that failed again, with their attempt count bumped. URLs that
+// now turn out not to exist are dropped. Once stopping returns true the
+// remaining entries are kept as they are.
+func Retry(entries []Entry, fetch func(rawURL string) error, stopping func() bool, logf func(format string, args ...any)) (int, []Entry) {
+	recovered := 0
+	var failed []Entry
+	for i, e := range entries {
+		if stopping() {
+			logf("Stopped with %d of %d URLs not retried", len(entries)-i, len(entries))
+			failed = append(failed, entries[i:]...)
+			break
+		}
+		err := fetch(e.URL)
+		if err == nil {
+			recovered++
+			logf("[%d/%d] Recovered %s", i+1, len(entries), e.URL)
+			continue
+		}
+		if errors.Is(err, fetcherr.ErrNotFound) {
+			logf("[%d/%d] Dropping %s: %v", i+1, len(entries), e.URL, err)
+			continue
+		}
+		logf("[%d/%d] Still failing %s: %v", i+1, len(entries), e.URL, err)
+		failed = append(failed, NewEntry(e.URL, err, e.Attempts+1))
+	}
+	return recovered, failed
+}
@@ -0,0 +1,410 @@
+// Package diff compares two snapshots of a crawler's output by natural key
+// and reports the records that were added, removed or changed, with the
+// before and after value of every changed field. Changes are written as
+// NDJSON, one record per line, and summed up per field for people:
+//
+//	trustmark_status changed for 312 businesses
+package diff
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"crawlkit/export"
+)
+
+// Spec says how the records of one output file are matched and compared
+type Spec struct {
+	Noun string   // the records in the plural, such as "businesses"
+	Key  []string // fields of the natural key
+	// Token is a field that identifies a record by itself where a record
+	// has it, such as a link token. Records are matched on it first, then
+	// on Key, so records sharing a key are still told apart.
+	Token  string
+	Ignore []string // fields that change on every run, such as list positions and fetch times
+}
+
+// Kinds of Change
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is one line of the NDJSON output
+type Change struct {
+	Kind   string          `json:"change"`
+	Key    map[string]any  `json:"key"`
+	Before json.RawMessage `json:"before,omitempty"` // the removed record
+	After  json.RawMessage `json:"after,omitempty"`  // the added record
+	Fields []FieldChange   `json:"fields,omitempty"`
+}
+
+// FieldChange is one changed field of a record. Nested fields are named by
+// their path, such as "lifecycle.expires"; lists are compared as a whole.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// Snapshot is one output file
+type Snapshot struct {
+	Path       string
+	Duplicates int // records whose key an earlier record already had
+	NoKey      int // records without every key field, which cannot be matched
+
+	records []*record
+}
+
+type record struct {
+	id     string // the key fields joined
+	token  string
+	key    map[string]any
+	raw    json.RawMessage
+	fields map[string]any
+}
+
+// Load reads a JSON array or NDJSON output file. A file that does not exist
+// is an empty snapshot, so the first crawl reports every record as added.
+func Load(path string, spec Spec) (*Snapshot, error) {
+	s := &Snapshot{Path: path}
+	ids := map[string]bool{}
+	err := export.EachRecord(path, func(raw json.RawMessage) error {
+		var value map[string]any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		fields := map[string]any{}
+		flatten("", value, fields)
+
+		key := map[string]any{}
+		parts := make([]string, len(spec.Key))
+		for i, name := range spec.Key {
+			v, ok := fields[name]
+			if !ok || v == nil || v == "" {
+				s.NoKey++
+				return nil
+			}
+			key[name] = v
+			parts[i] = fmt.Sprint(v)
+		}
+		r := &record{id: strings.Join(parts, "\x00"), key: key, raw: raw, fields: fields}
+		if spec.Token != "" {
+			if token, ok := fields[spec.Token].(string); ok && token != "" {
+				r.token = token
+				key[spec.Token] = token
+			}
+		}
+		if ids[r.id] {
+			s.Duplicates++
+		}
+		ids[r.id] = true
+		s.records = append(s.records, r)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	return s, err
+}
+
+// flatten adds the fields of an object to out, naming nested ones by path
+func flatten(prefix string, value map[string]any, out map[string]any) {
+	for name, v := range value {
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if object, ok := v.(map[string]any); ok {
+			flatten(name, object, out)
+			continue
+		}
+		out[name] = v
+	}
+}
+
+// Compare lists the changes from before to after: added and changed
+// records in the order of after, then removed ones in the order of before.
+// Records are matched on the token where both have one, then on the key,
+// records sharing a key in the order they appear. A partial after, from a
+// crawl that did not reach every record, reports no removed records.
+func Compare(before, after *Snapshot, spec Spec, partial bool) []Change {
+	ignore := map[string]bool{}
+	for _, name := range spec.Ignore {
+		ignore[name] = true
+	}
+	// the token identifies a record and is no change to it
+	if spec.Token != "" {
+		ignore[spec.Token] = true
+	}
+	pairs := match(before, after)
+
+	var changes []Change
+	for _, a := range after.records {
+		b, ok := pairs[a]
+		if !ok {
+			changes = append(changes, Change{Kind: Added, Key: a.key, After: a.raw})
+			continue
+		}
+		if fields := compareFields(b.fields, a.fields, ignore); len(fields) > 0 {
+			changes = append(changes, Change{Kind: Changed, Key: a.key, Fields: fields})
+		}
+	}
+	if partial {
+		return changes
+	}
+	matched := map[*record]bool{}
+	for _, b := range pairs {
+		matched[b] = true
+	}
+	for _, b := range before.records {
+		if !matched[b] {
+			changes = append(changes, Change{Kind: Removed, Key: b.key, Before: b.raw})
+		}
+	}
+	return changes
+}
+
+// match pairs every record of after with the record of before it replaces
+func match(before, after *Snapshot) map[*record]*record {
+	byToken := map[string]*record{}
+	byID := map[string][]*record{}
+	for _, b := range before.records {
+		if b.token != "" {
+			byToken[b.token] = b
+		}
+		byID[b.id] = append(byID[b.id], b)
+	}
+
+	pairs := map[*record]*record{}
+	taken := map[*record]bool{}
+	for _, a := range after.records {
+		if b := byToken[a.token]; a.token != "" && b != nil && !taken[b] {
+			pairs[a], taken[b] = b, true
+		}
+	}
+	for _, a := range after.records {
+		if _, ok := pairs[a]; ok {
+			continue
+		}
+		for _, b := range byID[a.id] {
+			if !taken[b] {
+				pairs[a], taken[b] = b, true
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+func compareFields(before, after map[string]any, ignore map[string]bool) []FieldChange {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if !ignore[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	var fields []FieldChange
+	for _, name := range sorted {
+		b, a := before[name], after[name]
+		if !reflect.DeepEqual(b, a) {
+			fields = append(fields, FieldChange{Field: name, Before: b, After: a})
+		}
+	}
+	return fields
+}
+
+// Summary counts the changes between two snapshots
+type Summary struct {
+	Spec      Spec
+	Before    *Snapshot
+	After     *Snapshot
+	Added     int
+	Removed   int
+	Changed   int
+	Unchanged int
+	Fields    map[string]int // field -> records in which it changed
+	Partial   bool           // after is a partial crawl, so nothing was counted as removed
+}
+
+// Summarize counts changes
+func Summarize(before, after *Snapshot, spec Spec, changes []Change, partial bool) *Summary {
+	s := &Summary{Spec: spec, Before: before, After: after, Fields: map[string]int{}, Partial: partial}
+	for _, c := range changes {
+		switch c.Kind {
+		case Added:
+			s.Added++
+		case Removed:
+			s.Removed++
+		case Changed:
+			s.Changed++
+			for _, f := range c.Fields {
+				s.Fields[f.Field]++
+			}
+		}
+	}
+	s.Unchanged = len(after.records) - s.Added - s.Changed
+	return s
+}
+
+// LogReport logs the summary, one line per changed field, most changed first
+func (s *Summary) LogReport(logf func(format string, args ...any)) {
+	if s == nil {
+		return
+	}
+	logf("%s -> %s: %d %s added, %d removed, %d changed, %d unchanged",
+		s.Before.Path, s.After.Path, s.Added, s.Spec.Noun, s.Removed, s.Changed, s.Unchanged)
+	fields := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		fields = append(fields, name)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		if s.Fields[fields[i]] != s.Fields[fields[j]] {
+			return s.Fields[fields[i]] > s.Fields[fields[j]]
+		}
+		return fields[i] < fields[j]
+	})
+	for _, name := range fields {
+		logf("%s changed for %d %s", name, s.Fields[name], s.Spec.Noun)
+	}
+	if s.Partial {
+		logf("%s is from a partial crawl; records it did not reach are not reported as removed", s.After.Path)
+	}
+	for _, snapshot := range []*Snapshot{s.Before, s.After} {
+		if snapshot.Duplicates > 0 {
+			how := "in the order they appear"
+			if s.Spec.Token != "" {
+				how = "by " + s.Spec.Token + ", or else " + how
+			}
+			logf("%s has %d records whose %s another record also has; they were matched %s",
+				snapshot.Path, snapshot.Duplicates, strings.Join(s.Spec.Key, "/"), how)
+		}
+		if snapshot.NoKey > 0 {
+			logf("%s has %d records without a %s, which were not compared", snapshot.Path, snapshot.NoKey, strings.Join(s.Spec.Key, "/"))
+		}
+	}
+}
+
+// Write writes changes as NDJSON to path, or to stdout for "-"
+func Write(path string, changes []Change) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	for _, c := range changes {
+		if err := encoder.Encode(c); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+// Run compares the snapshots at beforePath and afterPath and writes the
+// changes to output
+func Run(beforePath, afterPath, output string, spec Spec) (*Summary, error) {
+	before, err := Load(beforePath, spec)
+	if err != nil {
+		return nil, err
+	}
+	after, err := Load(afterPath, spec)
+	if err != nil {
+		return nil, err
+	}
+	return write(before, after, output, spec, false)
+}
+
+func write(before, after *Snapshot, output string, spec Spec, partial bool) (*Summary, error) {
+	changes := Compare(before, after, spec, partial)
+	if err := Write(output, changes); err != nil {
+		return nil, err
+	}
+	return Summarize(before, after, spec, changes, partial), nil
+}
+
+// RunCommand parses the diff command line and runs it
+//
+//	go run . diff --before last-week.json [--after output.json] [--output diff.ndjson]
+func RunCommand(args []string, spec Spec, input string) (*Summary, error) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	before := fs.String("before", "", "the earlier snapshot, a JSON array or NDJSON")
+	after := fs.String("after", input, "the later snapshot")
+	output := fs.String("output", "diff.ndjson", "NDJSON file to write the changes to, - for stdout")
+	fs.Parse(args)
+
+	if *before == "" {
+		return nil, errors.New("--before is required")
+	}
+	// unlike the crawl mode, a missing snapshot here is a mistake
+	for _, path := range []string{*before, *after} {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	return Run(*before, *after, *output, spec)
+}
+
+// Options are the flags of the crawl mode, which compares a crawl with the
+// output it replaces
+type Options struct {
+	Output string
+}
+
+// AddFlags registers --diff
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Output, "diff", "", "compare the new output with the one it replaces and write the changes as NDJSON to this file")
+}
+
+// Previous loads the output a crawl is about to replace, or returns nil
+// without --diff
+func (o Options) Previous(path string, spec Spec) (*Snapshot, error) {
+	if o.Output == "" {
+		return nil, nil
+	}
+	previous, err := Load(path, spec)
+	if err != nil {
+		return nil, err
+	}
+	previous.Path = "previous " + path
+	return previous, nil
+}
+
+// Finish compares previous with the output the crawl wrote to path. It
+// does nothing when previous is nil. A partial crawl, one that was stopped
+// or failed to fetch some pages or records, reports no removed records,
+// since the ones it did not reach would look removed.
+func (o Options) Finish(previous *Snapshot, path string, spec Spec, partial bool) (*Summary, error) {
+	if previous == nil {
+		return nil, nil
+	}
+	after, err := Load(path, spec)
+	if err != nil {
+		return nil, err
+	}
+	return write(previous, after, o.Output, spec, partial)
+}
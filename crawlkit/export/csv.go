@@ -0,0 +1,52 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// bom makes Excel read the file as UTF-8 instead of the system code page,
+// which garbles Thai
+const bom = "\ufeff"
+
+// WriteCSV writes sheet with a UTF-8 BOM and a header row. List cells are
+// joined with sep, and text that Excel would run as a formula is escaped.
+func WriteCSV(w io.Writer, sheet Sheet, sep string) error {
+	if _, err := io.WriteString(w, bom); err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	header := make([]string, len(sheet.Columns))
+	for i, c := range sheet.Columns {
+		header[i] = c.Name
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	line := make([]string, len(sheet.Columns))
+	for _, row := range sheet.Rows {
+		for i, cell := range row {
+			line[i] = text(cell, sep)
+			switch cell.(type) {
+			case string, []string:
+				line[i] = EscapeFormula(line[i])
+			}
+		}
+		if err := out.Write(line); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// EscapeFormula prefixes text starting with =, +, -, @, tab or carriage
+// return with a quote, so a spreadsheet shows it instead of evaluating it
+func EscapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,372 @@
+// Package export turns crawl output into files analysts can open in Excel:
+// CSV with a UTF-8 BOM, or XLSX with typed number and date cells. Records
+// are flattened along their JSON field names, so nested structs become
+// dotted columns and list fields are joined with a separator.
+package export
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are the export flags shared by the crawlers
+type Options struct {
+	Format    string
+	Input     string
+	Output    string
+	Separator string
+	CrawlDate string
+}
+
+// AddFlags registers --format, --input, --output, --separator and
+// --crawl-date. input is the default crawl output to read.
+func (o *Options) AddFlags(fs *flag.FlagSet, input string) {
+	fs.StringVar(&o.Format, "format", "csv", "csv, xlsx for a workbook with a sheet per entity type, or parquet")
+	fs.StringVar(&o.Input, "input", input, "crawl output to export, a JSON array or NDJSON")
+	fs.StringVar(&o.Output, "output", "", "file to write (default export.csv or export.xlsx), or directory for parquet (default lake)")
+	fs.StringVar(&o.Separator, "separator", "; ", "joins the items of list fields such as representatives")
+	fs.StringVar(&o.CrawlDate, "crawl-date", "", "parquet: crawl date to partition by, YYYY-MM-DD (default: when --input was last written)")
+}
+
+// Dir is the --output directory of a parquet export
+func (o Options) Dir() string {
+	if o.Output == "" {
+		return "lake"
+	}
+	return o.Output
+}
+
+// Type is how a column is written
+type Type int
+
+const (
+	Text Type = iota
+	Number
+	Date // a date, or a date and time when the value has a clock part
+)
+
+// Column is one column of a sheet
+type Column struct {
+	Name string
+	Type Type
+}
+
+// Sheet is a table of cells. A cell is nil, a string, a float64, a
+// time.Time or a []string for a list field.
+type Sheet struct {
+	Name    string
+	Columns []Column
+	Rows    [][]any
+}
+
+// Spec describes how one record type is exported
+type Spec struct {
+	Name    string   // entity name, used for the main sheet
+	Key     []string // columns repeated in the sheet of each list field
+	Numbers []string // text fields that hold numbers, such as a price
+}
+
+// Write flattens records, a slice of structs, and writes them to the
+// --output file as csv or xlsx. It returns the path written. Parquet needs
+// the explicit schema of a record type and is written with package lake.
+func Write(opts Options, spec Spec, records any) (string, error) {
+	if opts.Format != "csv" && opts.Format != "xlsx" {
+		return "", fmt.Errorf("unknown format %q, want csv or xlsx", opts.Format)
+	}
+	sheets, err := Flatten(spec, records)
+	if err != nil {
+		return "", err
+	}
+	path := opts.Output
+	if path == "" {
+		path = "export." + opts.Format
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	if opts.Format == "csv" {
+		err = WriteCSV(w, sheets[0], opts.Separator)
+	} else {
+		err = WriteXLSX(w, sheets, opts.Separator)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+type fieldKind int
+
+const (
+	kindText fieldKind = iota
+	kindNumber
+	kindTime
+	kindList       // []string
+	kindStructList // []struct, one column per field of the struct
+)
+
+type field struct {
+	name  string
+	index []int
+	kind  fieldKind
+	sub   []field // fields of the element of a kindStructList
+}
+
+// fields lists the exported fields of t by their JSON names, flattening
+// nested structs into dotted names
+func fields(t reflect.Type, prefix string, numbers []string) []field {
+	var out []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		name = prefix + name
+		ft := f.Type
+
+		switch {
+		case isTime(ft):
+			out = append(out, field{name: name, index: []int{i}, kind: kindTime})
+		case ft.Kind() == reflect.Struct:
+			for _, sub := range fields(ft, name+".", numbers) {
+				sub.index = append([]int{i}, sub.index...)
+				out = append(out, sub)
+			}
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.String:
+			out = append(out, field{name: name, index: []int{i}, kind: kindList})
+		case ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Struct:
+			out = append(out, field{name: name, index: []int{i}, kind: kindStructList, sub: fields(ft.Elem(), "", numbers)})
+		case isNumber(ft.Kind()) || (ft.Kind() == reflect.String && slices.Contains(numbers, name)):
+			out = append(out, field{name: name, index: []int{i}, kind: kindNumber})
+		default:
+			out = append(out, field{name: name, index: []int{i}, kind: kindText})
+		}
+	}
+	return out
+}
+
+// isTime matches time.Time and date types that embed it
+func isTime(t reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.Anonymous && f.Type == timeType {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func (f field) column() Column {
+	switch f.kind {
+	case kindNumber:
+		return Column{Name: f.name, Type: Number}
+	case kindTime:
+		return Column{Name: f.name, Type: Date}
+	}
+	return Column{Name: f.name, Type: Text}
+}
+
+// cell reads a scalar field of v
+func (f field) cell(v reflect.Value) any {
+	v = v.FieldByIndex(f.index)
+	switch f.kind {
+	case kindTime:
+		t, ok := v.Interface().(time.Time)
+		if !ok {
+			// a type embedding time.Time
+			for i := 0; i < v.NumField(); i++ {
+				if v.Type().Field(i).Anonymous && v.Field(i).Type() == timeType {
+					t = v.Field(i).Interface().(time.Time)
+				}
+			}
+		}
+		if t.IsZero() {
+			return nil
+		}
+		return t
+	case kindNumber:
+		if v.Kind() == reflect.String {
+			s := strings.ReplaceAll(strings.TrimSpace(v.String()), ",", "")
+			if s == "" {
+				return nil
+			}
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				return n
+			}
+			return v.String()
+		}
+		if v.CanInt() {
+			return float64(v.Int())
+		}
+		if v.CanUint() {
+			return float64(v.Uint())
+		}
+		return v.Float()
+	}
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// Flatten lays records, a slice of structs, out as sheets. The first sheet
+// has one row per record, with list fields kept as []string cells; it is
+// followed by a sheet per list field with one row per item, keyed by
+// spec.Key.
+func Flatten(spec Spec, records any) ([]Sheet, error) {
+	rv := reflect.ValueOf(records)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Struct {
+		return nil, errors.New("export: records must be a slice of structs")
+	}
+	all := fields(rv.Type().Elem(), "", spec.Numbers)
+
+	first := Sheet{Name: spec.Name}
+	var keys []field
+	var lists []field
+	for _, f := range all {
+		switch f.kind {
+		case kindList:
+			first.Columns = append(first.Columns, Column{Name: f.name, Type: Text})
+			lists = append(lists, f)
+		case kindStructList:
+			for _, sub := range f.sub {
+				first.Columns = append(first.Columns, Column{Name: f.name + "." + sub.name, Type: Text})
+			}
+			lists = append(lists, f)
+		default:
+			first.Columns = append(first.Columns, f.column())
+		}
+		if slices.Contains(spec.Key, f.name) && f.kind != kindList && f.kind != kindStructList {
+			keys = append(keys, f)
+		}
+	}
+
+	children := make([]Sheet, len(lists))
+	for i, f := range lists {
+		children[i].Name = f.name
+		for _, k := range keys {
+			children[i].Columns = append(children[i].Columns, k.column())
+		}
+		children[i].Columns = append(children[i].Columns, Column{Name: "position", Type: Number})
+		if f.kind == kindList {
+			children[i].Columns = append(children[i].Columns, Column{Name: f.name, Type: Text})
+		}
+		for _, sub := range f.sub {
+			children[i].Columns = append(children[i].Columns, sub.column())
+		}
+	}
+
+	for r := 0; r < rv.Len(); r++ {
+		record := rv.Index(r)
+		var row, key []any
+		for _, k := range keys {
+			key = append(key, k.cell(record))
+		}
+		child := 0
+		for _, f := range all {
+			switch f.kind {
+			case kindList:
+				items := record.FieldByIndex(f.index).Interface()
+				row = append(row, listOf(items))
+				for pos, item := range listOf(items) {
+					children[child].Rows = append(children[child].Rows, append(slices.Clone(key), float64(pos+1), item))
+				}
+				child++
+			case kindStructList:
+				items := record.FieldByIndex(f.index)
+				for _, sub := range f.sub {
+					var joined []string
+					for j := 0; j < items.Len(); j++ {
+						joined = append(joined, text(sub.cell(items.Index(j)), ""))
+					}
+					row = append(row, joined)
+				}
+				for j := 0; j < items.Len(); j++ {
+					line := append(slices.Clone(key), float64(j+1))
+					for _, sub := range f.sub {
+						line = append(line, sub.cell(items.Index(j)))
+					}
+					children[child].Rows = append(children[child].Rows, line)
+				}
+				child++
+			default:
+				row = append(row, f.cell(record))
+			}
+		}
+		first.Rows = append(first.Rows, row)
+	}
+	return append([]Sheet{first}, children...), nil
+}
+
+// listOf converts a named or plain []string to []string
+func listOf(items any) []string {
+	v := reflect.ValueOf(items)
+	out := make([]string, v.Len())
+	for i := range out {
+		out[i] = v.Index(i).String()
+	}
+	return out
+}
+
+// text formats a cell the way it is written to CSV, list items joined by sep
+func text(cell any, sep string) string {
+	switch c := cell.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case float64:
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	case time.Time:
+		if hasClock(c) {
+			return c.Format(time.RFC3339)
+		}
+		return c.Format("2006-01-02")
+	case []string:
+		return strings.Join(c, sep)
+	}
+	return fmt.Sprint(cell)
+}
+
+func hasClock(t time.Time) bool {
+	return t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0 || t.Nanosecond() != 0
+}
@@ -0,0 +1,105 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ReadRecords reads an output file written either as a JSON array or as
+// NDJSON, one record per line
+func ReadRecords[T any](path string) ([]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	first, err := firstByte(r)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(r)
+	if first == '[' {
+		var records []T
+		if err := decoder.Decode(&records); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return records, nil
+	}
+
+	var records []T
+	for decoder.More() {
+		var record T
+		if err := decoder.Decode(&record); err != nil {
+			return records, fmt.Errorf("%s: record %d: %v", path, len(records)+1, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// EachRecord streams an output file written as a JSON array or as NDJSON,
+// calling fn with each record as it is read, so a large file is never held
+// in memory. It stops at the first error fn returns.
+func EachRecord(path string, fn func(record json.RawMessage) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	first, err := firstByte(r)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(r)
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	for n := 1; decoder.More(); n++ {
+		var record json.RawMessage
+		if err := decoder.Decode(&record); err != nil {
+			return fmt.Errorf("%s: record %d: %v", path, n, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstByte peeks at the first byte that is not white space or a BOM
+func firstByte(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		case 0xEF:
+			// UTF-8 BOM
+			b, err := r.Peek(3)
+			if err != nil || string(b) != bom {
+				return c[0], nil
+			}
+			r.Discard(3)
+		default:
+			return c[0], nil
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package fetcherr is the error taxonomy shared by the crawlers. Failures are
+// wrapped in an *Error that carries the URL, the stage that failed and one of
+// the sentinel kinds below, so callers can branch with errors.Is and
+// errors.As and reports can group failures by Class.
+package fetcherr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"crawlkit/block"
+	"crawlkit/robots"
+)
+
+// Kinds of failure
+var (
+	// ErrNetwork is a request that got no usable response
+	ErrNetwork = errors.New("network error")
+	// ErrStatus is a response with an unexpected HTTP status; the code is
+	// in Error.Status
+	ErrStatus = errors.New("unexpected HTTP status")
+	// ErrDecode is a body that could not be decoded or parsed as HTML
+	ErrDecode = errors.New("cannot decode page")
+	// ErrStructure is a page without the tables or fields the parser expects
+	ErrStructure = errors.New("unexpected page structure")
+	// ErrNotFound is a page for a record that does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrBlocked is a captcha, WAF or rate-limit page (block.ErrBlocked)
+	ErrBlocked = block.ErrBlocked
+	// ErrDisallowed is a URL robots.txt does not allow (robots.ErrDisallowed)
+	ErrDisallowed = robots.ErrDisallowed
+)
+
+// Stages of a fetch
+const (
+	StageFetch  = "fetch"  // sending the request
+	StageRead   = "read"   // reading and checking the body
+	StageDecode = "decode" // charset and HTML decoding
+	StageParse  = "parse"  // extracting fields
+)
+
+// Classes, for grouping failures in reports and the dead-letter file
+const (
+	ClassNetwork   = "network"
+	ClassTimeout   = "timeout"
+	ClassStatus    = "http_status"
+	ClassDecode    = "decode"
+	ClassStructure = "structure"
+	ClassNotFound  = "not_found"
+	ClassBlocked   = "blocked"
+	ClassRobots    = "robots"
+	ClassCanceled  = "canceled"
+	ClassOther     = "other"
+)
+
+// Error is a failure at one stage of fetching one URL
+type Error struct {
+	Kind   error  // one of the Err* kinds
+	Stage  string // one of the Stage* names
+	URL    string
+	Status int   // HTTP status code, if there was a response
+	Err    error // underlying cause, may be nil
+}
+
+func (e *Error) Error() string {
+	msg := e.Stage + " " + e.URL
+	if e.Err == nil || !errors.Is(e.Err, e.Kind) {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" %d", e.Status)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap exposes both the kind and the cause to errors.Is and errors.As
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Class names the kind of failure
+func (e *Error) Class() string {
+	var netErr net.Error
+	switch e.Kind {
+	case ErrNetwork:
+		if errors.Is(e.Err, context.Canceled) {
+			return ClassCanceled
+		}
+		if errors.As(e.Err, &netErr) && netErr.Timeout() {
+			return ClassTimeout
+		}
+		return ClassNetwork
+	case ErrStatus:
+		return ClassStatus
+	case ErrDecode:
+		return ClassDecode
+	case ErrStructure:
+		return ClassStructure
+	case ErrNotFound:
+		return ClassNotFound
+	case ErrBlocked:
+		return ClassBlocked
+	case ErrDisallowed:
+		return ClassRobots
+	}
+	return ClassOther
+}
+
+// New wraps err as a failure of the given kind. An err that already is an
+// *Error is returned as is, so wrapping twice keeps the innermost stage.
+func New(kind error, stage, rawURL string, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return &Error{Kind: kind, Stage: stage, URL: rawURL, Err: err}
+}
+
+// Fetch wraps an error from sending a request or reading its body. Robots
+// and block errors keep their own kind, anything else is a network error.
+func Fetch(stage, rawURL string, err error) error {
+	kind := ErrNetwork
+	switch {
+	case errors.Is(err, ErrDisallowed):
+		kind = ErrDisallowed
+	case errors.Is(err, ErrBlocked):
+		kind = ErrBlocked
+	}
+	return New(kind, stage, rawURL, err)
+}
+
+// Status reports a response with an unexpected status code
+func Status(rawURL string, code int) error {
+	return &Error{Kind: ErrStatus, Stage: StageFetch, URL: rawURL, Status: code}
+}
+
+// Decode wraps a charset or HTML decoding error
+func Decode(rawURL string, err error) error {
+	return New(ErrDecode, StageDecode, rawURL, err)
+}
+
+// Structure reports a page the parser cannot make sense of
+func Structure(rawURL, format string, args ...any) error {
+	return &Error{Kind: ErrStructure, Stage: StageParse, URL: rawURL, Err: fmt.Errorf(format, args...)}
+}
+
+// NotFound reports a page for a record that does not exist
+func NotFound(rawURL, reason string) error {
+	return &Error{Kind: ErrNotFound, Stage: StageParse, URL: rawURL, Err: errors.New(reason)}
+}
+
+// Class groups any error, wrapped or not, into one of the Class* names
+func Class(err error) string {
+	var e *Error
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &e):
+		return e.Class()
+	case errors.Is(err, ErrDisallowed):
+		return ClassRobots
+	case errors.Is(err, ErrBlocked):
+		return ClassBlocked
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ClassTimeout
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return ClassNetwork
+	}
+	return ClassOther
+}
@@ -0,0 +1,217 @@
+// Package lake writes crawl datasets as Parquet for the data lake. Each
+// dataset is partitioned Hive-style by crawl date and province:
+//
+//	<dir>/<dataset>/crawl_date=2026-10-19/province=เชียงใหม่/part-crawl.parquet
+//
+// The schema of a dataset is the parquet tags of its row type. Each run
+// names its part files, so a retry does not replace the crawl's files while
+// the same run written again for the same crawl date does.
+package lake
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Options are the Parquet flags shared by the crawlers
+type Options struct {
+	Dir string
+}
+
+// AddFlags registers --parquet-dir
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Dir, "parquet-dir", "", "also write every record as Parquet under this directory, by crawl date and province")
+}
+
+// Unknown is the partition of records without a date or province
+const Unknown = "unknown"
+
+// Dataset writes rows of type T to one Parquet file per partition. Its
+// methods are no-ops on a nil Dataset, which is what Open returns without
+// a directory.
+type Dataset[T any] struct {
+	Dir  string // the dataset's own directory
+	Name string
+	Run  string // names the part files
+
+	mu    sync.Mutex
+	parts map[string]*part[T]
+	rows  int
+}
+
+type part[T any] struct {
+	path   string
+	file   *os.File
+	writer *parquet.GenericWriter[T]
+}
+
+// Open returns the dataset name under opts.Dir, or nil when it is empty.
+// run names the part files, such as "crawl" or the input file of an export.
+func Open[T any](opts Options, name, run string) *Dataset[T] {
+	if opts.Dir == "" {
+		return nil
+	}
+	return &Dataset[T]{Dir: filepath.Join(opts.Dir, name), Name: name, Run: partitionValue(run), parts: map[string]*part[T]{}}
+}
+
+// Write adds row to the partition of crawlDate and province
+func (d *Dataset[T]) Write(crawlDate time.Time, province string, row T) error {
+	if d == nil {
+		return nil
+	}
+	date := Unknown
+	if !crawlDate.IsZero() {
+		date = crawlDate.Format("2006-01-02")
+	}
+	dir := filepath.Join(d.Dir, "crawl_date="+date, "province="+partitionValue(province))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.parts[dir]
+	if !ok {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "part-"+d.Run+".parquet")
+		// written under a temporary name until Close adds the footer
+		file, err := os.Create(path + ".tmp")
+		if err != nil {
+			return err
+		}
+		p = &part[T]{path: path, file: file, writer: parquet.NewGenericWriter[T](file, parquet.Compression(&parquet.Zstd))}
+		d.parts[dir] = p
+	}
+	if _, err := p.writer.Write([]T{row}); err != nil {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	d.rows++
+	return nil
+}
+
+// Close finishes every partition file. It is safe to call more than once.
+func (d *Dataset[T]) Close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var firstErr error
+	for dir, p := range d.parts {
+		err := p.writer.Close()
+		if closeErr := p.file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(p.path+".tmp", p.path)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %v", p.path, err)
+		}
+		delete(d.parts, dir)
+	}
+	return firstErr
+}
+
+// LogReport logs how many rows were written
+func (d *Dataset[T]) LogReport(logf func(format string, args ...any)) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.rows == 0 {
+		return
+	}
+	logf("%d %s rows written as Parquet under %s", d.rows, d.Name, d.Dir)
+}
+
+// partitionValue keeps a value usable as one directory name
+func partitionValue(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Unknown
+	}
+	return strings.NewReplacer("/", "_", `\`, "_", "=", "_").Replace(s)
+}
+
+var (
+	// "จังหวัดเชียงใหม่", "จ.เชียงใหม่", "จังหวัด เชียงใหม่"
+	provinceRegex = regexp.MustCompile(`(?:จังหวัด|จ\.)\s*([^\s\d,()]+)`)
+	// Bangkok addresses name districts (เขต) instead of a province
+	bangkokRegex = regexp.MustCompile(`กรุงเทพ|กทม`)
+)
+
+const bangkok = "กรุงเทพมหานคร"
+
+// Province reads the province out of a Thai address, or returns "" when the
+// address does not name one
+func Province(address string) string {
+	// the province comes last; an earlier กรุงเทพ can be a road name
+	if matches := provinceRegex.FindAllStringSubmatch(address, -1); len(matches) > 0 {
+		province := matches[len(matches)-1][1]
+		if bangkokRegex.MatchString(province) {
+			return bangkok
+		}
+		return province
+	}
+	if bangkokRegex.MatchString(address) {
+		return bangkok
+	}
+	return ""
+}
+
+// Day converts t to the value of a DATE column, days since 1970-01-01. A
+// zero t gives 0, which an optional column stores as null.
+func Day(t time.Time) int32 {
+	if t.IsZero() {
+		return 0
+	}
+	y, m, d := t.Date()
+	return int32(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// Timestamp converts t to the value of a TIMESTAMP(millisecond) column. A
+// zero t gives 0, which an optional column stores as null; a zero time.Time
+// field would overflow instead.
+func Timestamp(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// Float parses a number the sites write as text, such as "1,250.00" or a
+// coordinate. Text that is not a number gives 0.
+func Float(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), 64)
+	return f
+}
+
+// InputRun names the run of an export after its input file, so exporting
+// the same file again replaces its part files
+func InputRun(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// CrawlDate is the crawl date of an output file: value, a YYYY-MM-DD flag,
+// when set, otherwise the day the file was last written
+func CrawlDate(value, path string) (time.Time, error) {
+	if value != "" {
+		return time.ParseInLocation("2006-01-02", value, time.Local)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
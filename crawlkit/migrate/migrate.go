@@ -0,0 +1,369 @@
+// Package migrate converts output files written in the crawlers' older
+// record shapes to the canonical records of package model. Files are read
+// and written one record at a time, so the size of the input does not
+// matter, and the converted records can be loaded into SQLite on the way.
+package migrate
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"crawlkit/export"
+	"crawlkit/model"
+	"crawlkit/sink"
+)
+
+// Format is one legacy record shape
+type Format struct {
+	Name    string
+	Source  string // the crawler that writes it
+	legacy  reflect.Type
+	convert func(data []byte) (record any, dropped []string, err error)
+}
+
+// dropper is a legacy record with fields its converter reads but does not
+// carry into the canonical record
+type dropper interface {
+	Dropped() []string
+}
+
+func format[T, C any](name, source string, convert func(T) C) Format {
+	return Format{
+		Name:   name,
+		Source: source,
+		legacy: reflect.TypeFor[T](),
+		convert: func(data []byte) (any, []string, error) {
+			var record T
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil, nil, err
+			}
+			var dropped []string
+			if d, ok := any(record).(dropper); ok {
+				dropped = d.Dropped()
+			}
+			return convert(record), dropped, nil
+		},
+	}
+}
+
+// Formats are the legacy shapes Detect knows, by the name --from takes
+var Formats = []Format{
+	format("thai-listing", "golang/test1.go", model.ThaiListing.Product),
+	format("listing", "golang", model.Listing.Product),
+	format("registry", "TEST", model.RegistryEntry.Enterprise),
+	format("smce", "smce", model.SMCERecord.Product),
+	format("category", "smce_productcategory", model.CategoryEnterprise.Enterprise),
+	format("details", "details", model.DetailProduct.Product),
+	format("trustmark", "dbd_trustmarkthai", model.TrustmarkRecord.Business),
+}
+
+// ErrCanonical is an input that is already in the canonical schema
+var ErrCanonical = errors.New("records are already in the canonical schema")
+
+// Detect picks the format whose fields cover most keys of record. On a tie
+// the format with fewer fields wins, so a plain listing row is not taken
+// for an smce record that happens to be missing its details.
+func Detect(record json.RawMessage) (Format, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return Format{}, fmt.Errorf("record is not a JSON object: %v", err)
+	}
+	if _, ok := fields["schema"]; ok {
+		return Format{}, ErrCanonical
+	}
+
+	best, bestScore := -1, 0
+	for i, f := range Formats {
+		score := 0
+		for key := range fields {
+			if _, ok := fieldByKey(f.legacy, key); ok {
+				score++
+			}
+		}
+		if score > bestScore || score == bestScore && best >= 0 && f.legacy.NumField() < Formats[best].legacy.NumField() {
+			best, bestScore = i, score
+		}
+	}
+	// most keys must be known, or the file is something else altogether
+	if best < 0 || bestScore*2 <= len(fields) {
+		return Format{}, errors.New("records match none of the legacy formats")
+	}
+	return Formats[best], nil
+}
+
+// Options are the flags of the migrate command
+type Options struct {
+	Input  string
+	Output string
+	From   string
+	Sink   sink.Options
+}
+
+// AddFlags registers --input, --output, --from and --sqlite
+func (o *Options) AddFlags(fs *flag.FlagSet, input string) {
+	fs.StringVar(&o.Input, "input", input, "output file to migrate, a JSON array or NDJSON")
+	fs.StringVar(&o.Output, "output", "", "NDJSON file to write the canonical records to (default <input>.v1.ndjson)")
+	fs.StringVar(&o.From, "from", "", "legacy format of the input, detected from the first record when empty: "+formatNames())
+	o.Sink.AddFlags(fs)
+}
+
+// OutputPath is --output, or the input file with its extension replaced
+func (o Options) OutputPath() string {
+	if o.Output != "" {
+		return o.Output
+	}
+	return strings.TrimSuffix(o.Input, filepath.Ext(o.Input)) + ".v1.ndjson"
+}
+
+// Report is what a migration did
+type Report struct {
+	Input, Output string
+	Format        Format
+	Records       int
+	Unmapped      map[string]int // field path -> records it was dropped from
+	NoKey         int            // records not loaded for want of a natural key
+
+	db *sink.DB
+}
+
+// LogReport logs the migration and every field that was not mapped
+func (r *Report) LogReport(logf func(format string, args ...any)) {
+	if r == nil {
+		return
+	}
+	logf("%d %s records (%s) from %s migrated to %s", r.Records, r.Format.Name, r.Format.Source, r.Input, r.Output)
+	paths := make([]string, 0, len(r.Unmapped))
+	for path := range r.Unmapped {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		logf("field %q of %d records could not be mapped and was dropped", path, r.Unmapped[path])
+	}
+	r.db.LogReport(logf)
+	if r.NoKey > 0 {
+		logf("%d records have no natural key and were not loaded into SQLite", r.NoKey)
+	}
+}
+
+// RunCommand parses the migrate command line and runs it
+//
+//	go run . migrate [--input output.json] [--output output.v1.ndjson] [--from details] [--sqlite crawl.db]
+func RunCommand(args []string, input string) (*Report, error) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	var opts Options
+	opts.AddFlags(fs, input)
+	fs.Parse(args)
+	return Run(opts)
+}
+
+// Run converts opts.Input to NDJSON at opts.OutputPath(), loading every
+// record into --sqlite when it is set. A partly written output is removed
+// on error.
+func Run(opts Options) (*Report, error) {
+	report := &Report{Input: opts.Input, Output: opts.OutputPath(), Unmapped: map[string]int{}}
+	if filepath.Clean(report.Output) == filepath.Clean(report.Input) {
+		return nil, errors.New("--output must differ from --input")
+	}
+	if opts.From != "" {
+		f, ok := lookup(opts.From)
+		if !ok {
+			return nil, fmt.Errorf("unknown --from %q, want one of %s", opts.From, formatNames())
+		}
+		report.Format = f
+	}
+
+	db, err := sink.Open(opts.Sink)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	report.db = db
+
+	file, err := os.Create(report.Output)
+	if err != nil {
+		return nil, err
+	}
+	out := bufio.NewWriter(file)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+
+	err = export.EachRecord(opts.Input, func(data json.RawMessage) error {
+		n := report.Records + 1
+		if report.Format.convert == nil {
+			f, err := Detect(data)
+			if err != nil {
+				return fmt.Errorf("%s: record %d: %v", opts.Input, n, err)
+			}
+			report.Format = f
+		}
+		record, dropped, err := report.Format.convert(data)
+		if err != nil {
+			return fmt.Errorf("%s: record %d: not a %s record: %v", opts.Input, n, report.Format.Name, err)
+		}
+		seen := map[string]bool{}
+		drop := func(path string) {
+			if !seen[path] {
+				seen[path] = true
+				report.Unmapped[path]++
+			}
+		}
+		unmapped("", data, report.Format.legacy, drop)
+		for _, path := range dropped {
+			drop(path)
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		if err := load(db, record); errors.Is(err, sink.ErrNoKey) {
+			report.NoKey++
+		} else if err != nil {
+			return err
+		}
+		report.Records++
+		return nil
+	})
+	if err == nil {
+		err = out.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(report.Output)
+		return nil, err
+	}
+	return report, nil
+}
+
+// load upserts a canonical record into db
+func load(db *sink.DB, record any) error {
+	switch r := record.(type) {
+	case model.Business:
+		b := sink.Business{
+			DataToken:       r.DataToken,
+			NationalID:      r.NationalID,
+			EntityType:      r.EntityType,
+			OwnerName:       r.OwnerName,
+			BusinessName:    r.BusinessName,
+			OnlineStoreName: r.OnlineStoreName,
+			Platform:        r.Platform,
+			BusinessType:    r.BusinessTypeTH,
+			TrustmarkStatus: r.TrustmarkStatus,
+			Record:          r,
+		}
+		if !r.Lifecycle.Expires.IsZero() {
+			b.ExpiresOn = r.Lifecycle.Expires.Format("2006-01-02")
+		}
+		return db.UpsertBusiness(b)
+	case model.Enterprise:
+		return db.UpsertEnterprise(sinkEnterprise(r))
+	case model.Product:
+		return db.UpsertProduct(sink.Product{
+			SMCEID:      r.SMCEID,
+			PSID:        r.PSID,
+			ProductName: r.Name,
+			Price:       r.PriceText,
+			Enterprise:  sinkEnterprise(r.Enterprise),
+			Record:      r,
+		})
+	}
+	return fmt.Errorf("cannot load a %T into SQLite", record)
+}
+
+func sinkEnterprise(e model.Enterprise) sink.Enterprise {
+	s := sink.Enterprise{
+		RegistrationCode: e.RegistrationCode,
+		SMCEID:           e.SMCEID,
+		Name:             e.Name,
+		BusinessGroup:    e.BusinessGroup,
+		BusinessType:     e.BusinessType,
+		Address:          e.Address,
+		Phone:            e.Phone,
+		Fax:              e.Fax,
+		Representatives:  e.Representatives,
+	}
+	if e.Location != nil {
+		s.Latitude, s.Longitude = e.Location.Latitude, e.Location.Longitude
+	}
+	return s
+}
+
+// unmapped calls report with the path of every key in data that t has no
+// field for, such as "metadata.reason" or "stores[].url"
+func unmapped(path string, data json.RawMessage, t reflect.Type, report func(path string)) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		// dates and times are structs written as strings
+		if json.Unmarshal(data, &fields) != nil {
+			return
+		}
+		for key, value := range fields {
+			field, ok := fieldByKey(t, key)
+			if !ok {
+				report(join(path, key))
+				continue
+			}
+			unmapped(join(path, key), value, field.Type, report)
+		}
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return
+		}
+		for _, item := range items {
+			unmapped(path+"[]", item, t.Elem(), report)
+		}
+	}
+}
+
+// fieldByKey finds the field of struct t that encoding/json fills from key
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		if name != "-" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func lookup(name string) (Format, bool) {
+	for _, f := range Formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+func formatNames() string {
+	names := make([]string, len(Formats))
+	for i, f := range Formats {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
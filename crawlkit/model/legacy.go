@@ -0,0 +1,361 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"crawlkit/thaidate"
+)
+
+// The record shapes the crawlers wrote before this package existed. They
+// stay as they are so old output files can still be read, and each has a
+// converter to the canonical type.
+
+// ThaiListing is an SMCE listing row keyed by the Thai labels of the site,
+// as golang/test1.go wrote it
+type ThaiListing struct {
+	EnterpriseName string `json:"ชื่อ"`
+	BusinessGroup  string `json:"กลุ่มกิจการ"`
+	BusinessType   string `json:"ประเภทกิจการ"`
+	ProductName    string `json:"ชื่อผลิตภัณฑ์/บริการ"`
+	ImageURL       string `json:"image_url"`
+}
+
+// Product converts the row. The listing has no IDs, so SMCEID and PSID are
+// empty.
+func (l ThaiListing) Product() Product {
+	return Listing(l).Product()
+}
+
+// Listing is the same listing row with snake_case keys, as golang/test2.go
+// writes it
+type Listing struct {
+	EnterpriseName string `json:"enterprise_name"`
+	BusinessGroup  string `json:"business_group"`
+	BusinessType   string `json:"business_type"`
+	ProductName    string `json:"product_name"`
+	ImageURL       string `json:"image_url"`
+}
+
+// Product converts the row. The listing has no IDs, so SMCEID and PSID are
+// empty.
+func (l Listing) Product() Product {
+	return Product{
+		Schema:   ProductSchema,
+		Name:     l.ProductName,
+		ImageURL: l.ImageURL,
+		Enterprise: Enterprise{
+			Schema:        EnterpriseSchema,
+			Name:          l.EnterpriseName,
+			BusinessGroup: l.BusinessGroup,
+			BusinessType:  l.BusinessType,
+		},
+	}
+}
+
+// RegistryEntry is a community enterprise from the TEST crawler
+type RegistryEntry struct {
+	Serial       int    `json:"serial"`
+	Registration string `json:"registration"`
+	Name         string `json:"name"`
+	Address      string `json:"address"`
+	Phone        string `json:"phone"`
+}
+
+// Enterprise converts the entry
+func (e RegistryEntry) Enterprise() Enterprise {
+	return Enterprise{
+		Schema:           EnterpriseSchema,
+		RegistrationCode: e.Registration,
+		Name:             e.Name,
+		Address:          e.Address,
+		Phone:            e.Phone,
+		Serial:           e.Serial,
+	}
+}
+
+// SMCERecord is a listing row with the details of its product, as the smce
+// crawler writes it
+type SMCERecord struct {
+	EnterpriseName       string `json:"enterprise_name"`
+	BusinessGroup        string `json:"business_group"`
+	BusinessType         string `json:"business_type"`
+	BusinessTypeID       string `json:"business_type_id,omitempty"`
+	ProvinceCode         string `json:"province_code,omitempty"`
+	AmphurCode           string `json:"amphur_code,omitempty"`
+	ProductName          string `json:"product_name"`
+	ImageURL             string `json:"image_url"`
+	SMCEID               string `json:"smce_id"`
+	PSID                 string `json:"ps_id"`
+	RegistrationCode     string `json:"registration_code"`
+	Address              string `json:"address"`
+	Phone                string `json:"phone"`
+	Fax                  string `json:"fax"`
+	AuthorityPerson      string `json:"authority_person"`
+	Properties           string `json:"properties"`
+	Composition          string `json:"composition"`
+	NutritionInfo        string `json:"nutrition_info"`
+	ProductionPeriod     string `json:"production_period"`
+	ProductionCapacity   string `json:"production_capacity"`
+	Price                string `json:"price"`
+	Standards            string `json:"standards"`
+	QualityAssurance     string `json:"quality_assurance"`
+	SeasonalUse          string `json:"seasonal_use"`
+	DistributionChannels string `json:"distribution_channels"`
+}
+
+// Product converts the record
+func (r SMCERecord) Product() Product {
+	return Product{
+		Schema:               ProductSchema,
+		SMCEID:               r.SMCEID,
+		PSID:                 r.PSID,
+		Name:                 r.ProductName,
+		ImageURL:             r.ImageURL,
+		Properties:           r.Properties,
+		Composition:          r.Composition,
+		NutritionInfo:        r.NutritionInfo,
+		ProductionPeriod:     r.ProductionPeriod,
+		ProductionCapacity:   r.ProductionCapacity,
+		Price:                parseNumber(r.Price),
+		PriceText:            r.Price,
+		Standards:            r.Standards,
+		QualityAssurance:     r.QualityAssurance,
+		SeasonalUse:          r.SeasonalUse,
+		DistributionChannels: r.DistributionChannels,
+		Enterprise: Enterprise{
+			Schema:           EnterpriseSchema,
+			RegistrationCode: r.RegistrationCode,
+			SMCEID:           r.SMCEID,
+			Name:             r.EnterpriseName,
+			BusinessGroup:    r.BusinessGroup,
+			BusinessType:     r.BusinessType,
+			BusinessTypeID:   r.BusinessTypeID,
+			Address:          r.Address,
+			ProvinceCode:     r.ProvinceCode,
+			AmphurCode:       r.AmphurCode,
+			Phone:            r.Phone,
+			Fax:              r.Fax,
+			Representatives:  nonEmpty(r.AuthorityPerson),
+		},
+	}
+}
+
+// CategoryEnterprise is an enterprise page, as smce_productcategory writes
+// it
+type CategoryEnterprise struct {
+	Serial           string  `json:"serial,omitempty"`
+	OrganizationName string  `json:"organization_name,omitempty"`
+	RegistrationCode string  `json:"registration_code,omitempty"`
+	Address          string  `json:"address,omitempty"`
+	Phone            string  `json:"phone,omitempty"`
+	Representatives  string  `json:"representatives,omitempty"`
+	Latitude         float64 `json:"latitude,omitempty"`
+	Longitude        float64 `json:"longitude,omitempty"`
+}
+
+// Enterprise converts the page
+func (e CategoryEnterprise) Enterprise() Enterprise {
+	serial, _ := strconv.Atoi(strings.TrimSpace(e.Serial))
+	return Enterprise{
+		Schema:           EnterpriseSchema,
+		RegistrationCode: e.RegistrationCode,
+		Name:             e.OrganizationName,
+		Address:          e.Address,
+		Phone:            e.Phone,
+		Representatives:  nonEmpty(e.Representatives),
+		Location:         newLocation(e.Latitude, e.Longitude),
+		Serial:           serial,
+	}
+}
+
+// DetailProduct is a product_detail.php page, as the details crawler writes
+// it. Files from before products carried their IDs (details/test1.go) have
+// no smce_id, ps_id, coordinates or metadata.
+type DetailProduct struct {
+	SMCEID               string          `json:"smce_id"`
+	PSID                 string          `json:"ps_id"`
+	OrganizationName     string          `json:"organization_name"`
+	RegistrationCode     string          `json:"registration_code"`
+	Address              string          `json:"address"`
+	Phone                string          `json:"phone"`
+	Fax                  string          `json:"fax"`
+	Representatives      []string        `json:"representatives"`
+	ProductName          string          `json:"product_name"`
+	Properties           string          `json:"properties"`
+	Composition          string          `json:"composition"`
+	NutritionInfo        string          `json:"nutrition_info"`
+	ProductionCapacity   string          `json:"production_capacity"`
+	PricePerTon          string          `json:"price_per_ton"`
+	Standards            string          `json:"standards"`
+	QualityAssurance     string          `json:"quality_assurance"`
+	ProductionPeriod     string          `json:"production_period"`
+	SeasonalUse          string          `json:"seasonal_use"`
+	DistributionChannels string          `json:"distribution_channels"`
+	Latitude             string          `json:"latitude"`
+	Longitude            string          `json:"longitude"`
+	Metadata             *DetailMetadata `json:"metadata"`
+}
+
+// DetailMetadata is the fetch record of a DetailProduct
+type DetailMetadata struct {
+	PageStatus string    `json:"page_status"`
+	SourceURL  string    `json:"source_url"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+// Product converts the page
+func (p DetailProduct) Product() Product {
+	product := Product{
+		Schema:               ProductSchema,
+		SMCEID:               p.SMCEID,
+		PSID:                 p.PSID,
+		Name:                 p.ProductName,
+		Properties:           p.Properties,
+		Composition:          p.Composition,
+		NutritionInfo:        p.NutritionInfo,
+		ProductionPeriod:     p.ProductionPeriod,
+		ProductionCapacity:   p.ProductionCapacity,
+		Price:                parseNumber(p.PricePerTon),
+		PriceText:            p.PricePerTon,
+		Standards:            p.Standards,
+		QualityAssurance:     p.QualityAssurance,
+		SeasonalUse:          p.SeasonalUse,
+		DistributionChannels: p.DistributionChannels,
+		Enterprise: Enterprise{
+			Schema:           EnterpriseSchema,
+			RegistrationCode: p.RegistrationCode,
+			SMCEID:           p.SMCEID,
+			Name:             p.OrganizationName,
+			Address:          p.Address,
+			Phone:            p.Phone,
+			Fax:              p.Fax,
+			Representatives:  p.Representatives,
+		},
+	}
+	if lat, lng := parseNumber(p.Latitude), parseNumber(p.Longitude); lat != nil && lng != nil {
+		product.Enterprise.Location = newLocation(*lat, *lng)
+	}
+	if p.Metadata != nil {
+		product.Source = &Source{URL: p.Metadata.SourceURL, PageStatus: p.Metadata.PageStatus, FetchedAt: p.Metadata.FetchedAt}
+	}
+	return product
+}
+
+// TrustmarkRecord is a business, as dbd_trustmarkthai writes it, with the
+// fields the converter reads. Files from before the lifecycle and stores were
+// added have only the text dates.
+type TrustmarkRecord struct {
+	DataToken          string    `json:"data_token,omitempty"`
+	OwnerName          string    `json:"owner_name"`
+	BusinessName       string    `json:"business_name"`
+	NationalID         string    `json:"national_id"`
+	EntityType         string    `json:"entity_type,omitempty"`
+	JuristicID         string    `json:"juristic_id,omitempty"`
+	JuristicOfficeCode string    `json:"juristic_office_code,omitempty"`
+	NationalIDMask     string    `json:"national_id_mask,omitempty"`
+	NationalIDChecksum string    `json:"national_id_checksum,omitempty"`
+	OnlineStoreName    string    `json:"online_store_name"`
+	Platform           string    `json:"platform"`
+	Stores             []Store   `json:"stores"`
+	BusinessTypeTH     string    `json:"business_type_th"`
+	BusinessTypeEN     string    `json:"business_type_en"`
+	AddressTH          string    `json:"address_th"`
+	AddressEN          string    `json:"address_en"`
+	TrustmarkStatus    string    `json:"trustmark_status"`
+	RegistrationDate   string    `json:"registration_date"`
+	DBDRegisteredDate  string    `json:"dbd_registered_date"`
+	DBDRenewalDate     string    `json:"dbd_renewal_date"`
+	DBDExpirationDate  string    `json:"dbd_expiration_date"`
+	RegisteredDateEN   string    `json:"registered_date_en"`
+	ExpirationDateEN   string    `json:"expiration_date_en"`
+	Lifecycle          Lifecycle `json:"lifecycle"`
+}
+
+// Business converts the record
+func (r TrustmarkRecord) Business() Business {
+	return Business{
+		Schema:             BusinessSchema,
+		DataToken:          r.DataToken,
+		NationalID:         r.NationalID,
+		EntityType:         r.EntityType,
+		JuristicID:         r.JuristicID,
+		JuristicOfficeCode: r.JuristicOfficeCode,
+		NationalIDMask:     r.NationalIDMask,
+		NationalIDChecksum: r.NationalIDChecksum,
+		OwnerName:          r.OwnerName,
+		BusinessName:       r.BusinessName,
+		OnlineStoreName:    r.OnlineStoreName,
+		Platform:           r.Platform,
+		Stores:             r.Stores,
+		BusinessTypeTH:     r.BusinessTypeTH,
+		BusinessTypeEN:     r.BusinessTypeEN,
+		AddressTH:          r.AddressTH,
+		AddressEN:          r.AddressEN,
+		TrustmarkStatus:    r.TrustmarkStatus,
+		StartedOn:          r.RegistrationDate,
+		Lifecycle:          r.lifecycle(),
+	}
+}
+
+// Dropped returns the keys of the record Business does not carry over. A
+// record with a lifecycle keeps only that, not the text dates it was built
+// from, and registered_date_en, the renewal date in English, is never used.
+func (r TrustmarkRecord) Dropped() []string {
+	dates := []struct{ key, value string }{
+		{"dbd_registered_date", r.DBDRegisteredDate},
+		{"dbd_renewal_date", r.DBDRenewalDate},
+		{"dbd_expiration_date", r.DBDExpirationDate},
+		{"registered_date_en", r.RegisteredDateEN},
+		{"expiration_date_en", r.ExpirationDateEN},
+	}
+	var dropped []string
+	for _, date := range dates {
+		if date.value == "" {
+			continue
+		}
+		if r.Lifecycle.Status != "" || date.key == "registered_date_en" {
+			dropped = append(dropped, date.key)
+		}
+	}
+	return dropped
+}
+
+// lifecycle returns the record's lifecycle, or works one out from the text
+// dates of a record written without it. Whether such a record had expired
+// depends on when it was crawled, so unless the status text says otherwise
+// its status is unknown.
+func (r TrustmarkRecord) lifecycle() Lifecycle {
+	if r.Lifecycle.Status != "" {
+		return r.Lifecycle
+	}
+	l := Lifecycle{
+		Status:          "unknown",
+		FirstRegistered: parseDate(r.DBDRegisteredDate),
+		Renewed:         parseDate(r.DBDRenewalDate),
+		Expires:         parseDate(r.DBDExpirationDate, r.ExpirationDateEN),
+	}
+	if status := StatusFromText(r.TrustmarkStatus); status != "" {
+		l.Status = status
+	}
+	return l
+}
+
+// parseDate returns the first of values that is a Thai or English date
+func parseDate(values ...string) Date {
+	for _, value := range values {
+		if t, ok := thaidate.Parse(value, time.UTC); ok {
+			return Date{t}
+		}
+	}
+	return Date{}
+}
+
+// nonEmpty wraps a single value as a list, or returns nil for ""
+func nonEmpty(s string) []string {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil
+	}
+	return []string{s}
+}
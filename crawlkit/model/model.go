@@ -0,0 +1,181 @@
+// Package model is the canonical shape of the records the crawlers collect:
+// trustmark businesses, SMCE community enterprises and SMCE products. The
+// crawlers still write their own shapes; the migrate command converts their
+// output files into this one with legacy.go, and every converted record
+// carries its schema version.
+package model
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema versions, written to the "schema" field of every record. A change
+// that renames or retypes a field bumps the version.
+const (
+	BusinessSchema   = "business/v1"
+	EnterpriseSchema = "enterprise/v1"
+	ProductSchema    = "product/v1"
+)
+
+// Business is a DBD Registered trustmark record from trustmarkthai.com
+type Business struct {
+	Schema             string    `json:"schema"`
+	DataToken          string    `json:"data_token,omitempty"` // data= of the popup.php URL
+	NationalID         string    `json:"national_id"`
+	EntityType         string    `json:"entity_type,omitempty"`
+	JuristicID         string    `json:"juristic_id,omitempty"`
+	JuristicOfficeCode string    `json:"juristic_office_code,omitempty"` // registering office, juristic persons only
+	NationalIDMask     string    `json:"national_id_mask,omitempty"`     // the digits the site hides, such as "#######******"
+	NationalIDChecksum string    `json:"national_id_checksum,omitempty"` // valid, invalid or masked
+	OwnerName          string    `json:"owner_name"`
+	BusinessName       string    `json:"business_name"`
+	OnlineStoreName    string    `json:"online_store_name"`
+	Platform           string    `json:"platform"`
+	Stores             []Store   `json:"stores"`
+	BusinessTypeTH     string    `json:"business_type_th"`
+	BusinessTypeEN     string    `json:"business_type_en"`
+	AddressTH          string    `json:"address_th"`
+	AddressEN          string    `json:"address_en"`
+	TrustmarkStatus    string    `json:"trustmark_status"`
+	StartedOn          string    `json:"started_on,omitempty"` // start of commerce, as the site writes it
+	Lifecycle          Lifecycle `json:"lifecycle"`
+}
+
+// Store is one online storefront of a business
+type Store struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url,omitempty"`
+	Handle   string `json:"handle,omitempty"`
+}
+
+// Lifecycle is the certification state of a trustmark
+type Lifecycle struct {
+	Status          string `json:"status"` // active, expired, suspended, revoked or unknown
+	FirstRegistered Date   `json:"first_registered"`
+	Renewed         Date   `json:"renewed"`
+	Expires         Date   `json:"expires"`
+}
+
+// StatusFromText reads the lifecycle status a trustmark status text states:
+// revoked, suspended or expired, in Thai or English. It returns "" when the
+// text states none of them and the expiry date decides.
+func StatusFromText(text string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "เพิกถอน") || strings.Contains(text, "revoke"):
+		return "revoked"
+	case strings.Contains(text, "ระงับ") || strings.Contains(text, "suspend"):
+		return "suspended"
+	case strings.Contains(text, "หมดอายุ") || strings.Contains(text, "expire"):
+		return "expired"
+	}
+	return ""
+}
+
+// Enterprise is an SMCE community enterprise
+type Enterprise struct {
+	Schema           string    `json:"schema"`
+	RegistrationCode string    `json:"registration_code"`
+	SMCEID           string    `json:"smce_id,omitempty"`
+	Name             string    `json:"name"`
+	BusinessGroup    string    `json:"business_group,omitempty"`
+	BusinessType     string    `json:"business_type,omitempty"`
+	BusinessTypeID   string    `json:"business_type_id,omitempty"`
+	Address          string    `json:"address,omitempty"`
+	ProvinceCode     string    `json:"province_code,omitempty"`
+	AmphurCode       string    `json:"amphur_code,omitempty"`
+	Phone            string    `json:"phone,omitempty"`
+	Fax              string    `json:"fax,omitempty"`
+	Representatives  []string  `json:"representatives,omitempty"`
+	Location         *Location `json:"location,omitempty"`
+	Serial           int       `json:"serial,omitempty"` // position in the listing it came from
+}
+
+// Product is an SMCE product or service, with the enterprise that offers it
+type Product struct {
+	Schema               string     `json:"schema"`
+	SMCEID               string     `json:"smce_id"`
+	PSID                 string     `json:"ps_id"`
+	Name                 string     `json:"name"`
+	ImageURL             string     `json:"image_url,omitempty"`
+	Properties           string     `json:"properties,omitempty"`
+	Composition          string     `json:"composition,omitempty"`
+	NutritionInfo        string     `json:"nutrition_info,omitempty"`
+	ProductionPeriod     string     `json:"production_period,omitempty"`
+	ProductionCapacity   string     `json:"production_capacity,omitempty"`
+	Price                *float64   `json:"price,omitempty"`
+	PriceText            string     `json:"price_text,omitempty"` // the price as the site writes it
+	Standards            string     `json:"standards,omitempty"`
+	QualityAssurance     string     `json:"quality_assurance,omitempty"`
+	SeasonalUse          string     `json:"seasonal_use,omitempty"`
+	DistributionChannels string     `json:"distribution_channels,omitempty"`
+	Enterprise           Enterprise `json:"enterprise"`
+	Source               *Source    `json:"source,omitempty"`
+}
+
+// Source records where and when a record was fetched
+type Source struct {
+	URL        string    `json:"url"`
+	PageStatus string    `json:"page_status,omitempty"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+// Location is a point in WGS 84 degrees
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// dateLayout is how Date values are written to JSON
+const dateLayout = "2006-01-02"
+
+// Date is a calendar date, serialized as YYYY-MM-DD or null
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// newLocation returns nil for a missing or 0,0 position
+func newLocation(lat, lng float64) *Location {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+	return &Location{Latitude: lat, Longitude: lng}
+}
+
+// parseNumber reads a number written as text, such as "1,250.00"; it
+// returns nil for text that is not one
+func parseNumber(s string) *float64 {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
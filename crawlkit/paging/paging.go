@@ -0,0 +1,160 @@
+// Package paging works out how many result pages a listing has and notices
+// when a crawl has run past the end of it.
+package paging
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	// ErrEmptyPage is returned by Tracker.Check for a page with no rows
+	ErrEmptyPage = errors.New("page has no rows")
+	// ErrRepeatedPage is returned by Tracker.Check when a page has the same
+	// rows as one already seen, which is what the sites serve past the end
+	ErrRepeatedPage = errors.New("page repeats an earlier page")
+	// ErrTooManyFailures is returned by Tracker.Failed when the crawl
+	// should give up on a listing it cannot read
+	ErrTooManyFailures = errors.New("too many listing pages failed")
+)
+
+// totalRegex matches the result count shown above SMCE listings,
+// e.g. "ค้นพบทั้งหมด 84,692 รายการ"
+var totalRegex = regexp.MustCompile(`ทั้งหมด\s*([0-9,]+)\s*รายการ`)
+
+// TotalResults returns the total result count printed on a listing page
+func TotalResults(doc *goquery.Document) (int, bool) {
+	matches := totalRegex.FindStringSubmatch(doc.Text())
+	if len(matches) != 2 {
+		return 0, false
+	}
+	total, err := strconv.Atoi(strings.ReplaceAll(matches[1], ",", ""))
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+// MaxLinkedPage returns the highest page number linked from the pager, read
+// from the given query parameter of every link on the page. Pagers that link
+// a window of pages make this a lower bound of the last page.
+func MaxLinkedPage(doc *goquery.Document, param string) int {
+	maxPage := 0
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		if page, err := strconv.Atoi(u.Query().Get(param)); err == nil && page > maxPage {
+			maxPage = page
+		}
+	})
+	return maxPage
+}
+
+// MaxFailures is how many pages in a row may fail before Tracker.Failed
+// gives up on a listing whose end is not known
+const MaxFailures = 5
+
+// Tracker follows a crawl through a listing: how far the listing goes, the
+// rows of every page fetched so far and the pages that failed in a row
+type Tracker struct {
+	param    string // query parameter of the pager links
+	pageSize int    // rows per page, 0 when the result count cannot be used
+	seen     map[[sha256.Size]byte]int
+	last     int  // the last page as far as known
+	exact    bool // last comes from the result count, not the pager
+	failures int
+}
+
+// NewTracker returns a Tracker for a listing whose pager links carry the
+// page number in param and which shows pageSize rows a page (0 if unknown)
+func NewTracker(param string, pageSize int) *Tracker {
+	return &Tracker{param: param, pageSize: pageSize, seen: make(map[[sha256.Size]byte]int)}
+}
+
+// Observe reads how far the listing goes from a fetched page and reports
+// whether that moved the last known page. The total result count gives the
+// last page outright. Without it the highest linked page is only a lower
+// bound, because the SMCE pagers link a window of ten pages, so it is read
+// again on every page until Check finds the end.
+func (t *Tracker) Observe(doc *goquery.Document) bool {
+	if t.exact {
+		return false
+	}
+	if t.pageSize > 0 {
+		if total, ok := TotalResults(doc); ok {
+			t.last, t.exact = (total+t.pageSize-1)/t.pageSize, true
+			return true
+		}
+	}
+	if linked := MaxLinkedPage(doc, t.param); linked > t.last {
+		t.last = linked
+		return true
+	}
+	return false
+}
+
+// String describes the last known page, for logs
+func (t *Tracker) String() string {
+	if t.exact {
+		return fmt.Sprintf("%d pages", t.last)
+	}
+	return fmt.Sprintf("at least %d pages", t.last)
+}
+
+// More reports whether page may be part of the listing. Only the result
+// count can rule a page out; otherwise Check or Failed ends the crawl.
+func (t *Tracker) More(page int) bool {
+	return !t.exact || page <= t.last
+}
+
+// Failed records a page that could not be fetched. Unless the result count
+// gave the end of the listing it returns ErrTooManyFailures when the crawl
+// should stop: on page 1, before anything is known about the listing, or
+// after MaxFailures pages in a row, as a site that is down would otherwise
+// be walked without end.
+func (t *Tracker) Failed(page int) error {
+	t.failures++
+	switch {
+	case t.exact:
+		return nil
+	case len(t.seen) == 0 && t.last == 0:
+		return fmt.Errorf("%w: page %d failed before the size of the listing was known", ErrTooManyFailures, page)
+	case t.failures >= MaxFailures:
+		return fmt.Errorf("%w: %d pages up to page %d failed", ErrTooManyFailures, t.failures, page)
+	}
+	return nil
+}
+
+// Check records the rows of a page, identified by any per-row key such
+// as a detail link or the row text. It returns ErrEmptyPage or
+// ErrRepeatedPage when the crawl should stop.
+func (t *Tracker) Check(page int, rows []string) error {
+	t.failures = 0
+	if len(rows) == 0 {
+		return ErrEmptyPage
+	}
+
+	h := sha256.New()
+	for _, row := range rows {
+		h.Write([]byte(row))
+		h.Write([]byte{0})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+
+	if first, ok := t.seen[sum]; ok {
+		return fmt.Errorf("%w (same rows as page %d)", ErrRepeatedPage, first)
+	}
+	t.seen[sum] = page
+	return nil
+}
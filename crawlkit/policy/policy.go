@@ -0,0 +1,260 @@
+// Package policy decides what a crawl does when a fetch fails: stop, skip the
+// URL, or retry it first. A per-host circuit breaker stops hammering a host
+// that keeps failing, and every skipped URL is kept for the final report and
+// the dead-letter file.
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+)
+
+// Error modes
+const (
+	FailFast      = "fail-fast"
+	Skip          = "skip"
+	RetryThenSkip = "retry-then-skip"
+)
+
+var (
+	// ErrAbort is returned in fail-fast mode; the crawl should save what it
+	// has and stop
+	ErrAbort = errors.New("aborting crawl")
+	// ErrSkipped is returned when a URL was given up on and recorded
+	ErrSkipped = errors.New("skipped")
+	// ErrCircuitOpen is returned when a shutdown cut short the wait for a
+	// host whose breaker is open; the URL is not recorded
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// Options are the error policy flags shared by the crawlers
+type Options struct {
+	Mode             string
+	Retries          int
+	Backoff          time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	Report           string
+}
+
+// AddFlags registers --on-error, --retries, --retry-backoff,
+// --breaker-threshold, --breaker-cooldown and --skipped-report
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Mode, "on-error", RetryThenSkip, "what to do when a fetch fails: fail-fast, skip or retry-then-skip")
+	fs.IntVar(&o.Retries, "retries", 2, "retry-then-skip: extra attempts per URL")
+	fs.DurationVar(&o.Backoff, "retry-backoff", 5*time.Second, "retry-then-skip: wait before the first retry, doubled after each")
+	fs.IntVar(&o.BreakerThreshold, "breaker-threshold", 5, "open a host's circuit after this many failed URLs in a row (0 disables)")
+	fs.DurationVar(&o.BreakerCooldown, "breaker-cooldown", 2*time.Minute, "wait this long for an open host before probing it again")
+	fs.StringVar(&o.Report, "skipped-report", "skipped.json", "write skipped URLs and their reasons here")
+}
+
+// Skipped is one URL the crawl gave up on
+type Skipped struct {
+	URL       string    `json:"url"`
+	Reason    string    `json:"reason"`
+	Attempts  int       `json:"attempts"`
+	SkippedAt time.Time `json:"skipped_at"`
+}
+
+// Policy applies the options to each fetch. Do runs the fetch directly on a
+// nil Policy.
+type Policy struct {
+	Options
+	Logf        func(format string, args ...any)
+	Sleep       func(time.Duration)
+	DeadLetters *deadletter.Queue // gets every URL given up on that a later run could fetch
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	skipped  []Skipped
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	open      bool
+}
+
+// New checks the options and returns a Policy
+func New(opts Options) (*Policy, error) {
+	switch opts.Mode {
+	case FailFast, Skip, RetryThenSkip:
+	default:
+		return nil, fmt.Errorf("unknown --on-error %q (want %s, %s or %s)", opts.Mode, FailFast, Skip, RetryThenSkip)
+	}
+	return &Policy{
+		Options:  opts,
+		Logf:     log.Printf,
+		Sleep:    time.Sleep,
+		breakers: map[string]*breaker{},
+	}, nil
+}
+
+// Do runs fetch for rawURL under the policy. It returns nil on success, an
+// ErrAbort error in fail-fast mode and an ErrSkipped error otherwise. When
+// the host's circuit is open Do first sleeps until its cooldown is over and
+// the fetch is then the probe that closes or reopens it. A fetch cancelled
+// by a shutdown is neither retried nor recorded.
+func (p *Policy) Do(rawURL string, fetch func() error) error {
+	if p == nil {
+		return fetch()
+	}
+	host := hostOf(rawURL)
+
+	if wait := p.cooldown(host); wait > 0 {
+		p.Logf("Circuit for %s is open, waiting %s before probing it", host, wait.Round(time.Second))
+		p.Sleep(wait)
+		// Sleep only returns early on a shutdown
+		if p.cooldown(host) > 0 {
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, host)
+		}
+	}
+
+	attempts := 1
+	if p.Mode == RetryThenSkip {
+		attempts += p.Retries
+	}
+	backoff := p.Backoff
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fetch(); err == nil {
+			p.succeeded(host)
+			return nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if !retryable(err) || attempt == attempts {
+			return p.giveUp(rawURL, err, attempt)
+		}
+		p.Logf("Attempt %d/%d for %s failed: %v, retrying in %s", attempt, attempts, rawURL, err, backoff)
+		p.Sleep(backoff)
+		backoff *= 2
+	}
+	return p.giveUp(rawURL, err, attempts)
+}
+
+// cooldown returns how long host's open circuit has left before a probe may
+// go through, or 0
+func (p *Policy) cooldown(host string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.breakers[host]
+	if b == nil || !b.open {
+		return 0
+	}
+	return max(time.Until(b.openUntil), 0)
+}
+
+func (p *Policy) succeeded(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if b := p.breakers[host]; b != nil {
+		if b.open {
+			p.Logf("Circuit for %s closed again", host)
+		}
+		b.failures, b.open = 0, false
+	}
+}
+
+// giveUp records a URL that will not be fetched. Only failures of the host
+// count against its circuit: a missing record is an answer from a healthy
+// host, and a robots.txt disallow never reached it. A failed probe reopens
+// the circuit.
+func (p *Policy) giveUp(rawURL string, err error, attempts int) error {
+	switch {
+	case errors.Is(err, fetcherr.ErrDisallowed):
+	case errors.Is(err, fetcherr.ErrNotFound):
+		p.succeeded(hostOf(rawURL))
+	default:
+		p.failed(hostOf(rawURL))
+	}
+	if retryable(err) {
+		p.DeadLetters.Add(rawURL, err, attempts)
+	}
+	if p.Mode == FailFast {
+		return fmt.Errorf("%w: %s: %v", ErrAbort, rawURL, err)
+	}
+
+	p.mu.Lock()
+	p.skipped = append(p.skipped, Skipped{URL: rawURL, Reason: err.Error(), Attempts: attempts, SkippedAt: time.Now()})
+	p.mu.Unlock()
+	return fmt.Errorf("%w: %s: %v", ErrSkipped, rawURL, err)
+}
+
+// failed counts a failed URL against its host and opens the circuit at the
+// threshold. A failed probe reopens it straight away.
+func (p *Policy) failed(host string) {
+	if p.BreakerThreshold <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.breakers[host]
+	if b == nil {
+		b = &breaker{}
+		p.breakers[host] = b
+	}
+	b.failures++
+	if b.open || b.failures >= p.BreakerThreshold {
+		b.open = true
+		b.openUntil = time.Now().Add(p.BreakerCooldown)
+		p.Logf("Circuit for %s open after %d failures, probing again at %s", host, b.failures, b.openUntil.Format(time.TimeOnly))
+	}
+}
+
+// Skipped returns the URLs given up on so far
+func (p *Policy) Skipped() []Skipped {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Skipped(nil), p.skipped...)
+}
+
+// WriteReport saves the skipped URLs to the report file and logs a summary
+func (p *Policy) WriteReport() error {
+	if p == nil || p.Report == "" {
+		return nil
+	}
+	skipped := p.Skipped()
+	if skipped == nil {
+		skipped = []Skipped{}
+	}
+	data, err := json.MarshalIndent(skipped, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.Report, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	p.Logf("%d URLs skipped, listed in %s", len(skipped), p.Report)
+	return nil
+}
+
+// retryable reports whether fetching again could help: robots.txt and
+// missing records give the same answer every time
+func retryable(err error) bool {
+	return !errors.Is(err, fetcherr.ErrDisallowed) && !errors.Is(err, fetcherr.ErrNotFound)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,259 @@
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// dropped are headers that must not be replayed: cookies are secrets and
+// belong to the session, the rest are set by net/http per request
+// (a copied accept-encoding would also turn off transparent gzip).
+var dropped = map[string]bool{
+	"cookie":          true,
+	"host":            true,
+	"content-length":  true,
+	"accept-encoding": true,
+	"connection":      true,
+	"priority":        true,
+}
+
+// FromHeaders builds a profile from headers copied out of a browser request
+// to requestURL. A referer pointing at the same page as the request becomes
+// the {search} template.
+func FromHeaders(requestURL string, headers [][2]string) Profile {
+	p := Profile{Headers: map[string]string{}}
+	for _, h := range headers {
+		key := strings.ToLower(strings.TrimSpace(h[0]))
+		value := strings.TrimSpace(h[1])
+		if key == "" || strings.HasPrefix(key, ":") || dropped[key] {
+			continue
+		}
+		switch key {
+		case "user-agent":
+			p.UserAgent = value
+		case "accept-language":
+			p.AcceptLanguage = value
+		case "referer":
+			p.Referer = refererTemplate(requestURL, value)
+		default:
+			p.Headers[key] = value
+		}
+	}
+	return p
+}
+
+func refererTemplate(requestURL, referer string) string {
+	req, err1 := url.Parse(requestURL)
+	ref, err2 := url.Parse(referer)
+	if err1 == nil && err2 == nil && req.Host == ref.Host && req.Path == ref.Path {
+		return "{search}"
+	}
+	return referer
+}
+
+// ParseCurl reads a "Copy as cURL (bash)" command line
+func ParseCurl(command string) (Profile, error) {
+	args, err := splitShell(command)
+	if err != nil {
+		return Profile{}, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return Profile{}, errors.New("not a curl command")
+	}
+
+	var requestURL string
+	var headers [][2]string
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		next := func() string {
+			if i+1 < len(args) {
+				i++
+				return args[i]
+			}
+			return ""
+		}
+		switch arg {
+		case "-H", "--header":
+			if key, value, ok := strings.Cut(next(), ":"); ok {
+				headers = append(headers, [2]string{key, value})
+			}
+		case "-A", "--user-agent":
+			headers = append(headers, [2]string{"user-agent", next()})
+		case "-e", "--referer":
+			headers = append(headers, [2]string{"referer", next()})
+		case "-b", "--cookie", "-d", "--data", "--data-raw", "--data-binary", "-X", "--request", "-o", "--output":
+			next() // ค่าที่ไม่เกี่ยวกับ profile
+		case "--url":
+			requestURL = next()
+		default:
+			if !strings.HasPrefix(arg, "-") && requestURL == "" {
+				requestURL = arg
+			}
+		}
+	}
+	if requestURL == "" {
+		return Profile{}, errors.New("curl command has no URL")
+	}
+	return FromHeaders(requestURL, headers), nil
+}
+
+// splitShell splits a bash command line the way Chrome quotes it: single
+// quotes, $'...' strings, double quotes and backslash line continuations
+func splitShell(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			if s[i] != '\n' && s[i] != '\r' {
+				cur.WriteByte(s[i])
+				inArg = true
+			} else if s[i] == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		case c == '\'' || (c == '$' && i+1 < len(s) && s[i+1] == '\''):
+			ansi := c == '$'
+			if ansi {
+				i++
+			}
+			end := i + 1
+			for ; end < len(s) && s[end] != '\''; end++ {
+				if ansi && s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated single quote")
+			}
+			value := s[i+1 : end]
+			if ansi {
+				value = strings.NewReplacer(`\'`, `'`, `\"`, `"`, `\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(value)
+			}
+			cur.WriteString(value)
+			inArg = true
+			i = end
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' && end+1 < len(s) {
+					end++
+					cur.WriteByte(s[end])
+					continue
+				}
+				cur.WriteByte(s[end])
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+			i = end
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// har is the part of a HAR file a profile is built from
+type har struct {
+	Log struct {
+		Entries []struct {
+			Request struct {
+				URL     string `json:"url"`
+				Headers []struct {
+					Name  string `json:"name"`
+					Value string `json:"value"`
+				} `json:"headers"`
+			} `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+// ParseHAR builds a profile from the first entry of a HAR export whose URL
+// contains match (any entry when match is empty)
+func ParseHAR(data []byte, match string) (Profile, error) {
+	var archive har
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return Profile{}, fmt.Errorf("HAR: %v", err)
+	}
+	for _, entry := range archive.Log.Entries {
+		if !strings.Contains(entry.Request.URL, match) {
+			continue
+		}
+		headers := make([][2]string, 0, len(entry.Request.Headers))
+		for _, h := range entry.Request.Headers {
+			headers = append(headers, [2]string{h.Name, h.Value})
+		}
+		return FromHeaders(entry.Request.URL, headers), nil
+	}
+	return Profile{}, fmt.Errorf("HAR has no request matching %q", match)
+}
+
+// RunImport is the import-profile subcommand shared by the crawlers.
+//
+//	go run . import-profile --name chrome-mac --curl request.sh
+//	go run . import-profile --name chrome-mac --har session.har --match ProductC_Result.php
+func RunImport(args []string, stdin io.Reader) (string, error) {
+	fs := flag.NewFlagSet("import-profile", flag.ExitOnError)
+	name := fs.String("name", "", "name to store the profile under")
+	curlFile := fs.String("curl", "", `file holding a "Copy as cURL (bash)" command, - for stdin`)
+	harFile := fs.String("har", "", "HAR export to read")
+	match := fs.String("match", "", "use the first HAR request whose URL contains this")
+	file := fs.String("profiles", DefaultFile, "request profiles file")
+	fs.Parse(args)
+
+	if *name == "" {
+		return "", errors.New("--name is required")
+	}
+	if (*curlFile == "") == (*harFile == "") {
+		return "", errors.New("give exactly one of --curl or --har")
+	}
+
+	var p Profile
+	var err error
+	if *curlFile != "" {
+		var data []byte
+		if *curlFile == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(*curlFile)
+		}
+		if err != nil {
+			return "", err
+		}
+		p, err = ParseCurl(string(data))
+	} else {
+		var data []byte
+		if data, err = os.ReadFile(*harFile); err != nil {
+			return "", err
+		}
+		p, err = ParseHAR(data, *match)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err := Store(*file, *name, p); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("profile %q saved to %s with %d headers", *name, *file, len(p.Header(""))), nil
+}
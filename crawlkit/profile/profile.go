@@ -0,0 +1,145 @@
+// Package profile keeps named sets of request headers, so crawlers do not
+// carry browser headers pasted into their source.
+package profile
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultName is the profile crawlers use when --profile is not given
+const DefaultName = "chrome-windows"
+
+// DefaultFile is where profiles are read from and imported into
+const DefaultFile = "profiles.json"
+
+// Profile is one named set of request headers. Referer is a template:
+// {search} is replaced by the search form URL the crawler is paging through.
+type Profile struct {
+	UserAgent      string            `json:"user_agent,omitempty"`
+	AcceptLanguage string            `json:"accept_language,omitempty"`
+	Referer        string            `json:"referer,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+}
+
+// Builtin profiles are always available and can be overridden by name in
+// the profiles file
+var Builtin = map[string]Profile{
+	DefaultName: {
+		UserAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+		AcceptLanguage: "en-US,en;q=0.9,th;q=0.8",
+		Referer:        "{search}",
+		Headers: map[string]string{
+			"accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7",
+			"cache-control":             "no-cache",
+			"pragma":                    "no-cache",
+			"sec-ch-ua":                 `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			"sec-ch-ua-mobile":          "?0",
+			"sec-ch-ua-platform":        `"Windows"`,
+			"sec-fetch-dest":            "document",
+			"sec-fetch-mode":            "navigate",
+			"sec-fetch-site":            "same-origin",
+			"sec-fetch-user":            "?1",
+			"upgrade-insecure-requests": "1",
+		},
+	},
+}
+
+// Header returns the headers to send, with the referer template filled in
+func (p Profile) Header(search string) map[string]string {
+	header := make(map[string]string, len(p.Headers)+3)
+	for key, value := range p.Headers {
+		header[strings.ToLower(key)] = value
+	}
+	if p.UserAgent != "" {
+		header["user-agent"] = p.UserAgent
+	}
+	if p.AcceptLanguage != "" {
+		header["accept-language"] = p.AcceptLanguage
+	}
+	if referer := strings.ReplaceAll(p.Referer, "{search}", search); referer != "" {
+		header["referer"] = referer
+	}
+	return header
+}
+
+// Load reads the profiles file. A missing file is not an error, only the
+// builtin profiles are returned then.
+func Load(path string) (map[string]Profile, error) {
+	profiles := make(map[string]Profile, len(Builtin))
+	for name, p := range Builtin {
+		profiles[name] = p
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]Profile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, p := range stored {
+		profiles[name] = p
+	}
+	return profiles, nil
+}
+
+// Store adds or replaces one profile in the profiles file
+func Store(path, name string, p Profile) error {
+	stored := map[string]Profile{}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	stored[name] = p
+
+	data, err = json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Selection is the --profile and --profiles flags of a crawler
+type Selection struct {
+	Name string
+	File string
+}
+
+// AddFlags registers --profile and --profiles
+func (s *Selection) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.Name, "profile", DefaultName, "request profile to send headers from")
+	fs.StringVar(&s.File, "profiles", DefaultFile, "request profiles file")
+}
+
+// Profile loads the selected profile
+func (s Selection) Profile() (Profile, error) {
+	profiles, err := Load(s.File)
+	if err != nil {
+		return Profile{}, err
+	}
+	p, ok := profiles[s.Name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("no request profile %q (have %s)", s.Name, strings.Join(names, ", "))
+	}
+	return p, nil
+}
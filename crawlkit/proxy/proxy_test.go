@@ -0,0 +1,359 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// target is the site the crawlers would fetch; /forbidden answers 403
+func target(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/forbidden" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// httpProxy is a forward proxy for plain HTTP targets that counts the
+// requests it handles
+func httpProxy(t *testing.T, hits *atomic.Int64) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		req, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// socksProxy is a SOCKS5 server without authentication that supports
+// CONNECT to IPv4 addresses and domain names, and counts its connections
+func socksProxy(t *testing.T, hits *atomic.Int64) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	t.Cleanup(func() {
+		ln.Close()
+		// keep-alive connections stay open until the proxy goes away
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			hits.Add(1)
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				serveSOCKS(conn)
+			}()
+		}
+	}()
+	return "socks5://" + ln.Addr().String()
+}
+
+func serveSOCKS(conn net.Conn) {
+	defer conn.Close()
+	// greeting: version, methods; answer "no authentication"
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, head[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// request: version, CONNECT, reserved, address type
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(upstream, conn); done <- struct{}{} }()
+	go func() { io.Copy(conn, upstream); done <- struct{}{} }()
+	<-done
+}
+
+// deadProxy is the address of a proxy that refuses connections
+func deadProxy(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "http://" + addr
+}
+
+func get(t *testing.T, client *http.Client, rawURL string) (int, error) {
+	t.Helper()
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+func TestRotatePerRequest(t *testing.T) {
+	site := target(t)
+	var a, b, socks atomic.Int64
+	pool, err := NewPool([]string{httpProxy(t, &a), httpProxy(t, &b), socksProxy(t, &socks)}, PerRequest, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pool.Client(5 * time.Second)
+	for i := 0; i < 6; i++ {
+		if status, err := get(t, client, site.URL); err != nil || status != http.StatusOK {
+			t.Fatalf("request %d: status %d, err %v", i, status, err)
+		}
+	}
+	if a.Load() != 2 || b.Load() != 2 {
+		t.Errorf("HTTP proxies handled %d and %d requests, want 2 each", a.Load(), b.Load())
+	}
+	// keep-alive lets the SOCKS proxy carry both requests on one connection
+	if socks.Load() == 0 {
+		t.Error("SOCKS5 proxy handled no requests")
+	}
+	for _, s := range pool.Stats() {
+		if s.Requests != 2 || s.Successes != 2 || !s.Healthy {
+			t.Errorf("%s: %+v, want 2 successful requests", s.Proxy, s)
+		}
+	}
+}
+
+func TestRotatePerHost(t *testing.T) {
+	site := target(t)
+	var a, b atomic.Int64
+	pool, err := NewPool([]string{httpProxy(t, &a), httpProxy(t, &b)}, PerHost, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pool.Client(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		if _, err := get(t, client, site.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.Load() != 4 || b.Load() != 0 {
+		t.Errorf("proxies handled %d and %d requests, want all 4 on the first", a.Load(), b.Load())
+	}
+}
+
+func TestBenchFailingProxy(t *testing.T) {
+	site := target(t)
+	var good atomic.Int64
+	pool, err := NewPool([]string{deadProxy(t), httpProxy(t, &good)}, PerRequest, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pool.Client(5 * time.Second)
+
+	failures := 0
+	for i := 0; i < 6; i++ {
+		if _, err := get(t, client, site.URL); err != nil {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("%d requests failed, want 2 before the dead proxy is benched", failures)
+	}
+	stats := pool.Stats()
+	if stats[0].Healthy || stats[0].Requests != 2 || stats[0].Successes != 0 {
+		t.Errorf("dead proxy: %+v, want benched after 2 failed requests", stats[0])
+	}
+	if !stats[1].Healthy || stats[1].Successes != 4 {
+		t.Errorf("good proxy: %+v, want 4 successful requests", stats[1])
+	}
+
+	pool.Cooldown = 0
+	pool.mu.Lock()
+	pool.proxies[0].unhealthyUntil = time.Time{}
+	pool.mu.Unlock()
+	if !pool.Stats()[0].Healthy {
+		t.Error("dead proxy is still benched after its cooldown")
+	}
+}
+
+func TestForbiddenCountsAgainstProxy(t *testing.T) {
+	site := target(t)
+	var hits atomic.Int64
+	pool, err := NewPool([]string{httpProxy(t, &hits)}, PerRequest, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pool.Client(5 * time.Second)
+	for i := 0; i < 2; i++ {
+		if status, err := get(t, client, site.URL+"/forbidden"); err != nil || status != http.StatusForbidden {
+			t.Fatalf("status %d, err %v, want 403", status, err)
+		}
+	}
+	if _, err := get(t, client, site.URL); !errors.Is(err, ErrNoProxy) {
+		t.Errorf("err = %v, want ErrNoProxy after two 403s", err)
+	}
+}
+
+func TestTargetErrorIsNotProxyFault(t *testing.T) {
+	var hits atomic.Int64
+	pool, err := NewPool([]string{socksProxy(t, &hits)}, PerRequest, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the target closes the connection without answering
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	client := pool.Client(5 * time.Second)
+	for i := 0; i < 3; i++ {
+		if _, err := get(t, client, "http://"+ln.Addr().String()); err == nil {
+			t.Fatal("request to a target that hangs up succeeded")
+		}
+	}
+	if s := pool.Stats()[0]; !s.Healthy || s.Requests != 3 || s.Successes != 3 {
+		t.Errorf("%+v, want a healthy proxy credited with 3 requests", s)
+	}
+}
+
+func TestCancelledRequestNotCounted(t *testing.T) {
+	site := target(t)
+	var hits atomic.Int64
+	pool, err := NewPool([]string{httpProxy(t, &hits)}, PerRequest, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Client(5 * time.Second).Do(req); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if s := pool.Stats()[0]; !s.Healthy || s.Requests != 0 {
+		t.Errorf("%+v, want a healthy proxy with no requests counted", s)
+	}
+}
+
+func TestLogReport(t *testing.T) {
+	site := target(t)
+	var hits atomic.Int64
+	u, _ := url.Parse(httpProxy(t, &hits))
+	u.User = url.UserPassword("crawler", "secret")
+	pool, err := NewPool([]string{u.String(), deadProxy(t)}, PerRequest, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := pool.Client(5 * time.Second)
+	for i := 0; i < 2; i++ {
+		get(t, client, site.URL)
+	}
+
+	var lines []string
+	pool.LogReport(func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	})
+	if len(lines) != 2 {
+		t.Fatalf("got %d report lines, want 2: %q", len(lines), lines)
+	}
+	if !strings.Contains(lines[0], "1/1 ok (100.0%), healthy") || strings.Contains(lines[0], "secret") {
+		t.Errorf("line %q, want 1/1 ok and the password redacted", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "0/1 ok (0.0%), benched") {
+		t.Errorf("line %q, want 0/1 ok and benched", lines[1])
+	}
+
+	var none *Pool
+	none.LogReport(func(string, ...any) { t.Error("nil pool logged") })
+}
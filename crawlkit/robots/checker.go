@@ -0,0 +1,201 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrDisallowed is returned for a URL robots.txt does not let us fetch
+var ErrDisallowed = errors.New("disallowed by robots.txt")
+
+// Checker fetches robots.txt once per host and spaces out requests to each
+// host by its Crawl-delay. Its methods are no-ops on a nil Checker.
+type Checker struct {
+	Agent    string        // user-agent the rules are matched against
+	MinDelay time.Duration // our own per-host delay when Crawl-delay is lower
+	Ignore   bool          // --ignore-robots, for sites we have permission to crawl
+	Client   *http.Client  // for robots.txt and Get; may be swapped for a proxied one
+	Logf     func(format string, args ...any)
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+type host struct {
+	rules      *Rules
+	delay      time.Duration
+	last       time.Time
+	overridden bool
+}
+
+// Options is the --ignore-robots flag
+type Options struct {
+	Ignore bool
+}
+
+// AddFlags registers --ignore-robots
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Ignore, "ignore-robots", false, "fetch pages robots.txt disallows (only for sites we have permission to crawl; logged)")
+}
+
+// NewChecker returns a Checker for agent
+func NewChecker(agent string, opts Options) *Checker {
+	c := &Checker{
+		Agent:  agent,
+		Ignore: opts.Ignore,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Logf:   log.Printf,
+		hosts:  map[string]*host{},
+	}
+	if c.Ignore {
+		c.Logf("--ignore-robots is set: robots.txt rules will be logged but not enforced")
+	}
+	return c
+}
+
+// Check returns ErrDisallowed when robots.txt forbids u. With Ignore set the
+// first overridden URL of each host is logged and nil returned.
+func (c *Checker) Check(u *url.URL) error {
+	if c == nil {
+		return nil
+	}
+	h := c.host(u)
+	if h.rules.Allowed(c.Agent, u.RequestURI()) {
+		return nil
+	}
+	if !c.Ignore {
+		return fmt.Errorf("%w: %s", ErrDisallowed, u)
+	}
+
+	c.mu.Lock()
+	first := !h.overridden
+	h.overridden = true
+	c.mu.Unlock()
+	if first {
+		c.Logf("robots.txt of %s disallows %s, fetching anyway (--ignore-robots)", u.Host, u.Path)
+	}
+	return nil
+}
+
+// Wait sleeps until the host's delay has passed since the last request to
+// it, or returns the context's error if it is cancelled first
+func (c *Checker) Wait(ctx context.Context, u *url.URL) error {
+	if c == nil {
+		return nil
+	}
+	h := c.host(u)
+
+	c.mu.Lock()
+	next := h.last.Add(max(h.delay, c.MinDelay))
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	h.last = next
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Do checks and waits for req before sending it with client. The wait ends
+// early when the request's context is cancelled.
+func (c *Checker) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	if err := c.Check(req.URL); err != nil {
+		return nil, err
+	}
+	if err := c.Wait(req.Context(), req.URL); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// Get is Do for a plain GET with the Checker's client, or the default
+// client on a nil Checker
+func (c *Checker) Get(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return c.Do(c.Client, req)
+}
+
+// host returns the cached state of u's host, fetching robots.txt on first use.
+// A robots.txt that could not be fetched is not cached, so a later request
+// tries again.
+func (c *Checker) host(u *url.URL) *host {
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	h, ok := c.hosts[key]
+	c.mu.Unlock()
+	if ok {
+		return h
+	}
+
+	rules, err := c.fetch(key + "/robots.txt")
+	if err != nil {
+		c.Logf("Could not fetch robots.txt of %s, treating the host as disallowed: %v", u.Host, err)
+		return &host{rules: DisallowAll}
+	}
+	h = &host{rules: rules, delay: rules.CrawlDelay(c.Agent)}
+	if h.delay > 0 {
+		c.Logf("robots.txt of %s sets Crawl-delay %s", u.Host, h.delay)
+	}
+
+	c.mu.Lock()
+	if existing, ok := c.hosts[key]; ok {
+		h = existing
+	} else {
+		c.hosts[key] = h
+	}
+	c.mu.Unlock()
+	return h
+}
+
+// fetch downloads robots.txt. Per RFC 9309 a 4xx means no rules and a 5xx
+// means the host is unreachable.
+func (c *Checker) fetch(robotsURL string) (*Rules, error) {
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.Agent)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	case resp.StatusCode >= 400:
+		return AllowAll, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 500*1024)) // RFC 9309 อ่านอย่างน้อย 500 KiB
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(body)), nil
+}
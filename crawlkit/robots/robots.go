@@ -0,0 +1,180 @@
+// Package robots reads robots.txt (RFC 9309) and keeps crawlers to the
+// rules and Crawl-delay of every host they visit.
+package robots
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rules are the parsed groups of one robots.txt
+type Rules struct {
+	groups []group
+}
+
+type group struct {
+	agents []string
+	rules  []rule
+	delay  time.Duration
+}
+
+type rule struct {
+	allow   bool
+	pattern string
+}
+
+// AllowAll is used when a host has no robots.txt
+var AllowAll = &Rules{}
+
+// DisallowAll is used when robots.txt could not be fetched
+var DisallowAll = &Rules{groups: []group{{agents: []string{"*"}, rules: []rule{{pattern: "/"}}}}}
+
+// Parse reads a robots.txt body. Unknown lines are ignored.
+func Parse(body string) *Rules {
+	r := &Rules{}
+	var current *group
+	inAgents := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// user-agent ที่ต่อกันหลายบรรทัดอยู่ในกลุ่มเดียวกัน
+			if !inAgents {
+				r.groups = append(r.groups, group{})
+				current = &r.groups[len(r.groups)-1]
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil || (key == "disallow" && value == "") {
+				continue
+			}
+			current.rules = append(current.rules, rule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return r
+}
+
+// match returns the groups naming the product token of agent, or else the
+// * groups
+func (r *Rules) match(agent string) []*group {
+	token := productToken(agent)
+	var matched, wildcard []*group
+	for i := range r.groups {
+		g := &r.groups[i]
+		for _, name := range g.agents {
+			switch {
+			case name == "*":
+				wildcard = append(wildcard, g)
+			case token != "" && leadingToken(name) == token:
+				matched = append(matched, g)
+			}
+		}
+	}
+	if len(matched) > 0 {
+		return matched
+	}
+	return wildcard
+}
+
+// productToken returns the lower-cased product token of a user-agent string,
+// the name a robots.txt group uses for the crawler: "googlebot" for
+// "Googlebot/2.1" and for "Mozilla/5.0 (compatible; Googlebot/2.1; ...)". A
+// browser user-agent names no crawler, so it gets "" and only the * groups
+// apply to it.
+func productToken(agent string) string {
+	agent = strings.ToLower(strings.TrimSpace(agent))
+	if !strings.HasPrefix(agent, "mozilla/") {
+		return leadingToken(agent)
+	}
+	if _, rest, ok := strings.Cut(agent, "compatible;"); ok {
+		return leadingToken(strings.TrimSpace(rest))
+	}
+	return ""
+}
+
+// leadingToken returns the run of letters, "_" and "-" s starts with
+func leadingToken(s string) string {
+	end := strings.IndexFunc(s, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '-')
+	})
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+// Allowed reports whether agent may fetch path (with query). The longest
+// matching rule wins and allow wins a tie.
+func (r *Rules) Allowed(agent, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	allowed, longest := true, -1
+	for _, g := range r.match(agent) {
+		for _, rule := range g.rules {
+			if !matchPattern(rule.pattern, path) {
+				continue
+			}
+			if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+				allowed, longest = rule.allow, n
+			}
+		}
+	}
+	return allowed
+}
+
+// CrawlDelay returns the Crawl-delay set for agent, or 0
+func (r *Rules) CrawlDelay(agent string) time.Duration {
+	var delay time.Duration
+	for _, g := range r.match(agent) {
+		delay = max(delay, g.delay)
+	}
+	return delay
+}
+
+// matchPattern matches a path against a rule supporting * and a trailing $
+func matchPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+	return !anchored || rest == ""
+}
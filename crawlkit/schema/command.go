@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"crawlkit/export"
+)
+
+// RunSchema writes s as a JSON Schema document
+//
+//	go run . schema [--output <name>.schema.json]
+func RunSchema(args []string, s *Schema, name string) (string, error) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	output := fs.String("output", name+".schema.json", "file to write the JSON Schema to, - for stdout")
+	fs.Parse(args)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	data = append(data, '\n')
+	if *output == "-" {
+		_, err := os.Stdout.Write(data)
+		return "", err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("JSON Schema of %s written to %s", s.Title, *output), nil
+}
+
+// Report is the outcome of validating one output file
+type Report struct {
+	Input      string
+	Records    int
+	Invalid    int         // records with at least one violation
+	Violations []Violation // the first --max of them
+	Total      int
+	Rules      map[string]int // "/*/phone: is not a valid e164" -> count
+}
+
+// LogReport logs the listed violations and how often each rule was broken
+func (r *Report) LogReport(logf func(format string, args ...any)) {
+	if r == nil {
+		return
+	}
+	if r.Invalid == 0 {
+		logf("all %d records in %s match the schema", r.Records, r.Input)
+		return
+	}
+	for _, v := range r.Violations {
+		logf("%s", v)
+	}
+	if more := r.Total - len(r.Violations); more > 0 {
+		logf("... and %d more violations", more)
+	}
+	rules := make([]string, 0, len(r.Rules))
+	for rule := range r.Rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if r.Rules[rules[i]] != r.Rules[rules[j]] {
+			return r.Rules[rules[i]] > r.Rules[rules[j]]
+		}
+		return rules[i] < rules[j]
+	})
+	for _, rule := range rules {
+		logf("%6d  %s", r.Rules[rule], rule)
+	}
+	logf("%d of %d records in %s violate the schema", r.Invalid, r.Records, r.Input)
+}
+
+// index is the record number at the start of a JSON pointer
+var index = regexp.MustCompile(`/\d+(/|$)`)
+
+// RunValidate checks every record of an output file against s, or against
+// the schema document given with --schema
+//
+//	go run . validate [--input output.json] [--schema <name>.schema.json] [--max 20]
+func RunValidate(args []string, s *Schema, input string) (*Report, error) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("input", input, "output file to validate, a JSON array or NDJSON")
+	schemaFile := fs.String("schema", "", "JSON Schema document to validate against instead of the built-in one")
+	max := fs.Int("max", 20, "violations to list one by one")
+	fs.Parse(args)
+
+	if *schemaFile != "" {
+		data, err := os.ReadFile(*schemaFile)
+		if err != nil {
+			return nil, err
+		}
+		s = &Schema{}
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("%s: %v", *schemaFile, err)
+		}
+	}
+	validator, err := NewValidator(s)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Input: *path, Rules: map[string]int{}}
+	err = export.EachRecord(*path, func(data json.RawMessage) error {
+		var record any
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("%s: record %d: %v", *path, report.Records+1, err)
+		}
+		// records are numbered as in the JSON array, from 0
+		violations := validator.Validate("/"+fmt.Sprint(report.Records), record)
+		report.Records++
+		if len(violations) == 0 {
+			return nil
+		}
+		report.Invalid++
+		for _, v := range violations {
+			if len(report.Violations) < *max {
+				report.Violations = append(report.Violations, v)
+			}
+			report.Total++
+			report.Rules[index.ReplaceAllString(v.Pointer, "/*$1")+": "+v.Message]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,217 @@
+// Package schema generates JSON Schema documents (draft 2020-12) for the
+// crawlers' record types from their Go types, and validates output files
+// against them. The Go type gives the shape: every field without omitempty
+// is required, slices and pointers may be null and no other fields are
+// allowed. Formats and enums the type cannot express are added per field.
+package schema
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema version of the generated documents
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, limited to the keywords Generate writes and
+// Validate checks
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 any                `json:"type,omitempty"` // a name or a list of names
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// Field adds what a Go type cannot say about one of its fields
+type Field struct {
+	Description string
+	// Format is a JSON Schema format: "date", "date-time" or "uri", or one
+	// of "e164" (an international phone number), "latitude" or "longitude"
+	// (decimal degrees, numbers or text)
+	Format  string
+	Pattern string // a regular expression for text the site writes its own way
+	Enum    []string
+	Empty   bool // "" is allowed besides the format, pattern or enum, for values the site leaves blank
+	Null    bool // null is allowed, for types that write a zero value as null
+}
+
+// Fields of the crawlers' records
+var (
+	Date      = Field{Format: "date", Null: true}
+	Phone     = Field{Pattern: phonePattern, Empty: true, Description: "as the site shows it, such as 0875012908, 02-123 4567 ต่อ 12 or -"}
+	PhoneE164 = Field{Format: "e164", Empty: true, Description: "the number beside it in E.164, such as +66812345678, or empty when that is not one Thai number"}
+	Latitude  = Field{Format: "latitude", Empty: true}
+	Longitude = Field{Format: "longitude", Empty: true}
+)
+
+// Patterns of the formats standard validators do not know, so they check
+// the documents too
+const (
+	e164Pattern    = `^\+[1-9][0-9]{1,14}$`
+	decimalPattern = `^-?[0-9]+(\.[0-9]+)?$`
+	// one or more numbers with the separators the sites use, an extension,
+	// or "-" for none
+	phonePattern = `^(-|\+?[0-9][0-9 ().,/+-]*( *(ต่อ|ext\.?|#) *[0-9]+)?)$`
+)
+
+var (
+	timeType      = reflect.TypeFor[time.Time]()
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+	textMarshaler = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// Generate returns the schema of T. fields is keyed by the path of a field
+// in its JSON form, such as "lifecycle.status" or "stores[].platform".
+func Generate[T any](title, description string, fields map[string]Field) *Schema {
+	s := generate(reflect.TypeFor[T](), "", fields)
+	s.Schema = Draft
+	s.Title = title
+	s.Description = description
+	return s
+}
+
+func generate(t reflect.Type, path string, fields map[string]Field) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+
+	s := &Schema{}
+	switch {
+	case t == timeType:
+		s.Type, s.Format = "string", "date-time"
+	case t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler),
+		t.Implements(textMarshaler) || reflect.PointerTo(t).Implements(textMarshaler):
+		// its JSON form is its own; the Field says what it is
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			s.Type = "string"
+		case reflect.Bool:
+			s.Type = "boolean"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s.Type = "integer"
+		case reflect.Float32, reflect.Float64:
+			s.Type = "number"
+		case reflect.Slice, reflect.Array:
+			s.Type = "array"
+			s.Items = generate(t.Elem(), path+"[]", fields)
+			nullable = nullable || t.Kind() == reflect.Slice
+		case reflect.Map:
+			s.Type = "object"
+			nullable = true
+		case reflect.Struct:
+			s.Type = "object"
+			s.Properties = map[string]*Schema{}
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				name, omitempty, ok := jsonName(f)
+				if !ok {
+					continue
+				}
+				s.Properties[name] = generate(f.Type, join(path, name), fields)
+				if !omitempty {
+					s.Required = append(s.Required, name)
+				}
+			}
+			closed := false
+			s.AdditionalProperties = &closed
+		}
+	}
+
+	if field, ok := fields[path]; ok {
+		s = field.apply(s)
+		nullable = nullable || field.Null
+	}
+	if nullable {
+		if name, ok := s.Type.(string); ok {
+			s.Type = []string{name, "null"}
+		}
+	}
+	return s
+}
+
+// apply adds the field's format, enum and description to s
+func (f Field) apply(s *Schema) *Schema {
+	if s.Type == nil {
+		s.Type = "string"
+	}
+	if f.Description != "" {
+		s.Description = f.Description
+	}
+	constraint := s
+	if f.Empty && f.Format == "" && f.Pattern == "" {
+		constraint.Enum = append(constraint.Enum, "")
+	} else if f.Empty && s.Type == "string" {
+		// the constraints go in their own branch so "" can skip them
+		constraint = &Schema{}
+		s.AnyOf = []*Schema{{Enum: []any{""}}, constraint}
+	}
+
+	switch f.Format {
+	case "":
+	case "latitude", "longitude":
+		limit := 90.0
+		if f.Format == "longitude" {
+			limit = 180
+		}
+		if s.Type == "number" {
+			min, max := -limit, limit
+			s.Minimum, s.Maximum = &min, &max
+			break
+		}
+		constraint.Format = f.Format
+		constraint.Pattern = decimalPattern
+	case "e164":
+		constraint.Format = f.Format
+		constraint.Pattern = e164Pattern
+	default:
+		constraint.Format = f.Format
+	}
+	if f.Pattern != "" {
+		constraint.Pattern = f.Pattern
+	}
+	for _, value := range f.Enum {
+		constraint.Enum = append(constraint.Enum, value)
+	}
+	return s
+}
+
+// jsonName is the name encoding/json gives field f, and whether it is
+// written at all
+func jsonName(f reflect.StructField) (name string, omitempty, ok bool) {
+	if !f.IsExported() {
+		return "", false, false
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return "", false, false
+	}
+	name, options, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+	return name, strings.Contains(","+options+",", ",omitempty,"), true
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,240 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Violation is one way a value breaks its schema
+type Violation struct {
+	Pointer string // JSON pointer to the value, such as "/12/lifecycle/expires"
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Pointer + ": " + v.Message
+}
+
+// Validator checks values against one schema
+type Validator struct {
+	schema   *Schema
+	patterns map[string]*regexp.Regexp
+}
+
+// NewValidator compiles the patterns of s
+func NewValidator(s *Schema) (*Validator, error) {
+	v := &Validator{schema: s, patterns: map[string]*regexp.Regexp{}}
+	if err := v.compile(s); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *Validator) compile(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Pattern != "" && v.patterns[s.Pattern] == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %v", s.Pattern, err)
+		}
+		v.patterns[s.Pattern] = re
+	}
+	for _, child := range s.AnyOf {
+		if err := v.compile(child); err != nil {
+			return err
+		}
+	}
+	for _, child := range s.Properties {
+		if err := v.compile(child); err != nil {
+			return err
+		}
+	}
+	return v.compile(s.Items)
+}
+
+// Validate checks a decoded JSON value, whose pointer is pointer, and
+// returns every violation in it
+func (v *Validator) Validate(pointer string, value any) []Violation {
+	var violations []Violation
+	v.validate(v.schema, pointer, value, &violations)
+	return violations
+}
+
+func (v *Validator) validate(s *Schema, pointer string, value any, out *[]Violation) {
+	if s == nil {
+		return
+	}
+	report := func(format string, args ...any) {
+		*out = append(*out, Violation{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if types := typeNames(s.Type); len(types) > 0 && !hasType(types, value) {
+		report("is %s, want %s", typeOf(value), strings.Join(types, " or "))
+		return
+	}
+	if len(s.Enum) > 0 && !contains(s.Enum, value) {
+		report("is not one of %s", enumText(s.Enum))
+	}
+	if len(s.AnyOf) > 0 {
+		// report the last branch, which holds the constraints of a Field
+		var last []Violation
+		for _, branch := range s.AnyOf {
+			last = nil
+			v.validate(branch, pointer, value, &last)
+			if len(last) == 0 {
+				break
+			}
+		}
+		*out = append(*out, last...)
+	}
+
+	switch value := value.(type) {
+	case string:
+		if s.Format != "" && !validFormat(s.Format, value) {
+			report("is not a valid %s", s.Format)
+		} else if re := v.patterns[s.Pattern]; re != nil && !re.MatchString(value) {
+			report("does not match %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			report("is below the minimum of %v", *s.Minimum)
+		}
+		if s.Maximum != nil && value > *s.Maximum {
+			report("is above the maximum of %v", *s.Maximum)
+		}
+	case []any:
+		for i, item := range value {
+			v.validate(s.Items, pointer+"/"+strconv.Itoa(i), item, out)
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				*out = append(*out, Violation{Pointer: pointer + "/" + escape(name), Message: "is missing"})
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			item := value[name]
+			child, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*out = append(*out, Violation{Pointer: pointer + "/" + escape(name), Message: "is not a field of the schema"})
+				}
+				continue
+			}
+			v.validate(child, pointer+"/"+escape(name), item, out)
+		}
+	}
+}
+
+// e164 matches the "e164" format the way its pattern in the documents does
+var e164 = regexp.MustCompile(e164Pattern)
+
+// validFormat checks the formats Generate writes; others pass
+func validFormat(format, value string) bool {
+	switch format {
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(value)
+		return err == nil && u.Scheme != "" && u.Host != ""
+	case "e164":
+		return e164.MatchString(value)
+	case "latitude", "longitude":
+		limit := 90.0
+		if format == "longitude" {
+			limit = 180
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		return err == nil && math.Abs(f) <= limit
+	}
+	return true
+}
+
+// typeNames reads the type keyword, a name or a list of names
+func typeNames(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	case []any:
+		names := make([]string, 0, len(t))
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func hasType(types []string, value any) bool {
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || t == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf is the JSON Schema type of a value decoded by encoding/json
+func typeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func contains(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumText(values []any) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// escape makes name one JSON pointer token
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,149 @@
+// Package shutdown turns SIGINT and SIGTERM into a graceful stop. The first
+// signal tells the crawl to start no new work; requests already in flight
+// get a grace period before their context is cancelled, and the crawler
+// then flushes what it has, writes its checkpoint and closes its files. A
+// second signal cancels the requests straight away.
+package shutdown
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Options are the shutdown flags shared by the crawlers
+type Options struct {
+	Grace      time.Duration
+	Checkpoint string
+}
+
+// AddFlags registers --shutdown-grace and --checkpoint
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&o.Grace, "shutdown-grace", 15*time.Second, "on Ctrl-C, let requests in flight finish for this long")
+	fs.StringVar(&o.Checkpoint, "checkpoint", "checkpoint.json", "write how far the crawl got here when it ends")
+}
+
+// Signal follows the signals for one run. Its methods are safe on a nil
+// Signal, which never stops.
+type Signal struct {
+	Options
+	Logf func(format string, args ...any)
+
+	stopping context.Context
+	stop     context.CancelFunc
+	requests context.Context
+	cancel   context.CancelFunc
+	signals  chan os.Signal
+	once     sync.Once
+}
+
+// Listen starts following SIGINT and SIGTERM. Call Close when done.
+func Listen(opts Options) *Signal {
+	s := &Signal{Options: opts, Logf: log.Printf, signals: make(chan os.Signal, 2)}
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	s.requests, s.cancel = context.WithCancel(context.Background())
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM)
+	go s.wait()
+	return s
+}
+
+func (s *Signal) wait() {
+	sig, ok := <-s.signals
+	if !ok {
+		return
+	}
+	s.Logf("Received %s, finishing requests in flight (up to %s) and saving; send it again to stop now", sig, s.Grace)
+	s.stop()
+
+	timer := time.NewTimer(s.Grace)
+	defer timer.Stop()
+	select {
+	case sig, ok := <-s.signals:
+		if ok {
+			s.Logf("Received %s again, cancelling requests in flight", sig)
+		}
+	case <-timer.C:
+		s.Logf("Grace period over, cancelling requests in flight")
+	case <-s.requests.Done():
+	}
+	s.cancel()
+	// ครั้งที่สามให้ระบบจัดการตามปกติ (ปิดโปรแกรมทันที)
+	signal.Stop(s.signals)
+}
+
+// Stopping reports whether a signal has arrived and no new work should start
+func (s *Signal) Stopping() bool {
+	return s != nil && s.stopping.Err() != nil
+}
+
+// Context is for requests: it is cancelled once the grace period is over
+func (s *Signal) Context() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	return s.requests
+}
+
+// Sleep sleeps for d, or less if a signal arrives. It fits the Sleep hooks
+// of block.Detector and policy.Policy.
+func (s *Signal) Sleep(d time.Duration) {
+	if s == nil {
+		time.Sleep(d)
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.stopping.Done():
+	}
+}
+
+// Close stops following signals and releases the contexts
+func (s *Signal) Close() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		signal.Stop(s.signals)
+		close(s.signals)
+		s.stop()
+		s.cancel()
+	})
+}
+
+// Checkpoint records how far a crawl got and whether it finished
+type Checkpoint struct {
+	Command     string            `json:"command"`
+	Interrupted bool              `json:"interrupted"`
+	Page        int               `json:"page,omitempty"` // last listing page fully processed
+	Records     int               `json:"records"`        // records written to Output
+	Output      string            `json:"output"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	SavedAt     time.Time         `json:"saved_at"`
+}
+
+// SaveCheckpoint writes cp to the --checkpoint file, marking it interrupted
+// when a signal arrived
+func (s *Signal) SaveCheckpoint(cp Checkpoint) error {
+	if s == nil || s.Checkpoint == "" {
+		return nil
+	}
+	cp.Interrupted = s.Stopping()
+	cp.SavedAt = time.Now()
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.Checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Checkpoint)
+}
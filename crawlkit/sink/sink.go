@@ -0,0 +1,340 @@
+// Package sink keeps every parsed record in a SQLite database next to the
+// JSON output. Rows are upserted on their natural keys as records are parsed,
+// so re-running a crawl refreshes rows instead of duplicating them and the
+// history of all runs can be queried with SQL.
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // pure-Go driver, registered as "sqlite"
+)
+
+// ErrNoKey is a record without the fields of its natural key
+var ErrNoKey = errors.New("record has no natural key")
+
+// schema is applied on every Open; each statement is idempotent
+const schema = `
+CREATE TABLE IF NOT EXISTS businesses (
+	business_key      TEXT PRIMARY KEY, -- national_id|online_store_name
+	data_token        TEXT,
+	national_id       TEXT,
+	entity_type       TEXT,
+	owner_name        TEXT,
+	business_name     TEXT,
+	online_store_name TEXT,
+	platform          TEXT,
+	business_type     TEXT,
+	trustmark_status  TEXT, -- as the site writes it
+	expires_on        TEXT,
+	record            TEXT NOT NULL,
+	first_seen        TEXT NOT NULL,
+	last_seen         TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS businesses_national_id ON businesses (national_id);
+
+CREATE TABLE IF NOT EXISTS enterprises (
+	registration_code TEXT PRIMARY KEY,
+	smce_id           TEXT,
+	name              TEXT,
+	business_group    TEXT,
+	business_type     TEXT,
+	address           TEXT,
+	phone             TEXT,
+	fax               TEXT,
+	latitude          REAL,
+	longitude         REAL,
+	first_seen        TEXT NOT NULL,
+	last_seen         TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS products (
+	smce_id           TEXT NOT NULL,
+	ps_id             TEXT NOT NULL,
+	registration_code TEXT,
+	product_name      TEXT,
+	price             TEXT,
+	record            TEXT NOT NULL,
+	first_seen        TEXT NOT NULL,
+	last_seen         TEXT NOT NULL,
+	PRIMARY KEY (smce_id, ps_id)
+);
+CREATE INDEX IF NOT EXISTS products_registration_code ON products (registration_code);
+
+CREATE TABLE IF NOT EXISTS representatives (
+	registration_code TEXT NOT NULL,
+	name              TEXT NOT NULL,
+	position          INTEGER NOT NULL,
+	PRIMARY KEY (registration_code, name)
+);
+`
+
+// Options are the SQLite flags shared by the crawlers
+type Options struct {
+	SQLite string
+}
+
+// AddFlags registers --sqlite
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.SQLite, "sqlite", "", "also upsert every record into this SQLite database")
+}
+
+// Business is a trustmarkthai.com record
+type Business struct {
+	DataToken       string // data= parameter of the popup.php URL, which only live crawls have
+	NationalID      string
+	EntityType      string
+	OwnerName       string
+	BusinessName    string
+	OnlineStoreName string
+	Platform        string
+	BusinessType    string
+	TrustmarkStatus string // the status text of the site
+	ExpiresOn       string // YYYY-MM-DD
+	Record          any    // the full record, stored as JSON
+}
+
+// key is the natural key of a business, the same for live, retried and
+// migrated records: one owner can run several online stores, each with its
+// own trustmark, and a masked ID such as "1309800******" is shared by many
+// owners
+func (b Business) key() string {
+	if b.NationalID == "" {
+		return ""
+	}
+	return b.NationalID + "|" + b.OnlineStoreName
+}
+
+// Enterprise is an SMCE community enterprise. Empty fields never overwrite
+// what an earlier record stored, since each crawler sees only some of them.
+type Enterprise struct {
+	RegistrationCode string
+	SMCEID           string
+	Name             string
+	BusinessGroup    string
+	BusinessType     string
+	Address          string
+	Phone            string
+	Fax              string
+	Latitude         float64
+	Longitude        float64
+	Representatives  []string // replaces the stored list when not empty
+}
+
+// Product is an SMCE product, with the enterprise that makes it
+type Product struct {
+	SMCEID      string
+	PSID        string
+	ProductName string
+	Price       string
+	Enterprise  Enterprise // upserted too when it has a registration code
+	Record      any        // the full record, stored as JSON
+}
+
+// DB upserts records into one SQLite file. Its methods are no-ops on a nil
+// DB, which is what Open returns without --sqlite.
+type DB struct {
+	File string
+
+	db     *sql.DB
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Open creates or opens the --sqlite database and its tables. It returns a
+// nil DB when the flag is empty.
+func Open(opts Options) (*DB, error) {
+	if opts.SQLite == "" {
+		return nil, nil
+	}
+	db, err := sql.Open("sqlite", opts.SQLite+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer; one connection keeps upserts from waiting on each other
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", opts.SQLite, err)
+	}
+	return &DB{File: opts.SQLite, db: db, counts: map[string]int{}}, nil
+}
+
+// UpsertBusiness inserts or refreshes a trustmark business
+func (d *DB) UpsertBusiness(b Business) error {
+	if d == nil {
+		return nil
+	}
+	key := b.key()
+	if key == "" {
+		return ErrNoKey
+	}
+	record, err := json.Marshal(b.Record)
+	if err != nil {
+		return err
+	}
+	now := timestamp()
+	return d.write("businesses", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+INSERT INTO businesses (business_key, data_token, national_id, entity_type, owner_name, business_name,
+	online_store_name, platform, business_type, trustmark_status, expires_on, record, first_seen, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (business_key) DO UPDATE SET
+	data_token = COALESCE(NULLIF(excluded.data_token, ''), data_token), national_id = excluded.national_id,
+	entity_type = excluded.entity_type, owner_name = excluded.owner_name,
+	business_name = excluded.business_name, online_store_name = excluded.online_store_name,
+	platform = excluded.platform, business_type = excluded.business_type,
+	trustmark_status = excluded.trustmark_status, expires_on = excluded.expires_on,
+	record = excluded.record, last_seen = excluded.last_seen`,
+			key, b.DataToken, b.NationalID, b.EntityType, b.OwnerName, b.BusinessName,
+			b.OnlineStoreName, b.Platform, b.BusinessType, b.TrustmarkStatus, nullString(b.ExpiresOn),
+			string(record), now, now)
+		return err
+	})
+}
+
+// UpsertEnterprise inserts or refreshes a community enterprise and its
+// representatives
+func (d *DB) UpsertEnterprise(e Enterprise) error {
+	if d == nil {
+		return nil
+	}
+	if e.RegistrationCode == "" {
+		return ErrNoKey
+	}
+	return d.write("enterprises", func(tx *sql.Tx) error {
+		return upsertEnterprise(tx, e, timestamp())
+	})
+}
+
+// UpsertProduct inserts or refreshes a product and, in the same
+// transaction, the enterprise that makes it
+func (d *DB) UpsertProduct(p Product) error {
+	if d == nil {
+		return nil
+	}
+	if p.SMCEID == "" || p.PSID == "" {
+		return ErrNoKey
+	}
+	record, err := json.Marshal(p.Record)
+	if err != nil {
+		return err
+	}
+	now := timestamp()
+	return d.write("products", func(tx *sql.Tx) error {
+		if p.Enterprise.RegistrationCode != "" {
+			if err := upsertEnterprise(tx, p.Enterprise, now); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(`
+INSERT INTO products (smce_id, ps_id, registration_code, product_name, price, record, first_seen, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (smce_id, ps_id) DO UPDATE SET
+	registration_code = COALESCE(excluded.registration_code, products.registration_code),
+	product_name = excluded.product_name, price = excluded.price,
+	record = excluded.record, last_seen = excluded.last_seen`,
+			p.SMCEID, p.PSID, nullString(p.Enterprise.RegistrationCode), p.ProductName, p.Price,
+			string(record), now, now)
+		return err
+	})
+}
+
+func upsertEnterprise(tx *sql.Tx, e Enterprise, now string) error {
+	_, err := tx.Exec(`
+INSERT INTO enterprises (registration_code, smce_id, name, business_group, business_type,
+	address, phone, fax, latitude, longitude, first_seen, last_seen)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (registration_code) DO UPDATE SET
+	smce_id = COALESCE(excluded.smce_id, enterprises.smce_id),
+	name = COALESCE(excluded.name, enterprises.name),
+	business_group = COALESCE(excluded.business_group, enterprises.business_group),
+	business_type = COALESCE(excluded.business_type, enterprises.business_type),
+	address = COALESCE(excluded.address, enterprises.address),
+	phone = COALESCE(excluded.phone, enterprises.phone),
+	fax = COALESCE(excluded.fax, enterprises.fax),
+	latitude = COALESCE(excluded.latitude, enterprises.latitude),
+	longitude = COALESCE(excluded.longitude, enterprises.longitude),
+	last_seen = excluded.last_seen`,
+		e.RegistrationCode, nullString(e.SMCEID), nullString(e.Name), nullString(e.BusinessGroup),
+		nullString(e.BusinessType), nullString(e.Address), nullString(e.Phone), nullString(e.Fax),
+		nullFloat(e.Latitude), nullFloat(e.Longitude), now, now)
+	if err != nil || len(e.Representatives) == 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM representatives WHERE registration_code = ?`, e.RegistrationCode); err != nil {
+		return err
+	}
+	for i, name := range e.Representatives {
+		if name == "" {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO representatives (registration_code, name, position) VALUES (?, ?, ?)`,
+			e.RegistrationCode, name, i+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write runs fn in a transaction and counts the upsert under table
+func (d *DB) write(table string, fn func(tx *sql.Tx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("%s: upsert into %s: %v", d.File, table, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.counts[table]++
+	return nil
+}
+
+// LogReport logs how many records were upserted into each table
+func (d *DB) LogReport(logf func(format string, args ...any)) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, table := range []string{"businesses", "enterprises", "products"} {
+		if n := d.counts[table]; n > 0 {
+			logf("%d records upserted into %s in %s", n, table, d.File)
+		}
+	}
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	if d == nil {
+		return nil
+	}
+	return d.db.Close()
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// nullString stores "" as NULL so COALESCE keeps the earlier value
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullFloat stores 0 as NULL; the sites leave coordinates blank, never 0,0
+func nullFloat(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: f != 0}
+}
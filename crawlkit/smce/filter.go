@@ -0,0 +1,172 @@
+// Package smce holds what the smce2023.doae.go.th crawlers share: URLs,
+// search filters and reference data.
+package smce
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// BaseURL is the root every SMCE page hangs off
+const BaseURL = "https://smce2023.doae.go.th/"
+
+const (
+	// ProductResultURL is the product (E-Catalog) search listing
+	ProductResultURL = BaseURL + "ProductC_Result.php"
+	// CategoryURL is the community enterprise search listing
+	CategoryURL = BaseURL + "ProductCategory/SmceCategory.php"
+)
+
+// Filter narrows an SMCE search. Empty fields mean "all".
+type Filter struct {
+	Region       string // เขต 1-9
+	Province     string // รหัสจังหวัด เช่น 10 = กรุงเทพมหานคร
+	Amphur       string // รหัสอำเภอ
+	Keyword      string
+	BusinessType string // business_type_id, ProductC_Result.php only
+	SMCEID       string // smce_id, ProductC_Result.php only
+	RefData      string // code tables from the refdata command, checked by Validate
+}
+
+// AddFlags registers --region, --province, --amphur, --keyword and --refdata
+func (f *Filter) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.Region, "region", "", "region code (เขต), empty for all")
+	fs.StringVar(&f.Province, "province", "", "province code, e.g. 10 for Bangkok, empty for all")
+	fs.StringVar(&f.Amphur, "amphur", "", "amphur (district) code, empty for all")
+	fs.StringVar(&f.Keyword, "keyword", "", "search keyword")
+	fs.StringVar(&f.RefData, "refdata", "", "code tables written by refdata, used to check the codes above")
+}
+
+// AddProductFlags registers the filters only the product listing supports
+func (f *Filter) AddProductFlags(fs *flag.FlagSet, defaultBusinessType string) {
+	fs.StringVar(&f.BusinessType, "business-type", defaultBusinessType, "business_type_id, empty for all")
+	fs.StringVar(&f.SMCEID, "smce-id", "", "only products of this enterprise")
+}
+
+// Validate checks that every code is numeric and, when --refdata is set,
+// that the code tables know them
+func (f Filter) Validate() error {
+	for name, value := range map[string]string{
+		"region":        f.Region,
+		"province":      f.Province,
+		"amphur":        f.Amphur,
+		"business-type": f.BusinessType,
+		"smce-id":       f.SMCEID,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			return fmt.Errorf("--%s must be a numeric code, got %q", name, value)
+		}
+	}
+	if _, err := charmap.Windows874.NewEncoder().String(f.Keyword); err != nil {
+		return fmt.Errorf("--keyword %q has characters the SMCE search cannot take: %v", f.Keyword, err)
+	}
+	if f.RefData == "" {
+		return nil
+	}
+	tables, err := LoadCodeTables(f.RefData)
+	if err != nil {
+		return err
+	}
+	return tables.Check(f)
+}
+
+// ProductQuery returns the ProductC_Result.php query string for a page
+func (f Filter) ProductQuery(pageSize, page int) url.Values {
+	q := url.Values{}
+	q.Set("page_size", strconv.Itoa(pageSize))
+	q.Set("PAGE", strconv.Itoa(page))
+	q.Set("business_type_id", f.BusinessType)
+	q.Set("smce_id", f.SMCEID)
+	q.Set("select_province", f.Province)
+	q.Set("select_region", f.Region)
+	q.Set("select_amphur", f.Amphur)
+	q.Set("key_word", f.keyword())
+	setWindow(q, page)
+	return q
+}
+
+// CategoryQuery returns the SmceCategory.php query string for a page
+func (f Filter) CategoryQuery(pageSize, page int) url.Values {
+	q := url.Values{}
+	q.Set("page_size", strconv.Itoa(pageSize))
+	q.Set("PAGE", strconv.Itoa(page))
+	q.Set("province_id", f.Province)
+	q.Set("region_id", f.Region)
+	q.Set("amphur_id", f.Amphur)
+	q.Set("key_word", f.keyword())
+	setWindow(q, page)
+	return q
+}
+
+// keyword is the keyword in windows-874, the charset of the SMCE pages.
+// Their search forms have no accept-charset, so a browser sends Thai in
+// that charset and url.Values must escape those bytes, not UTF-8.
+func (f Filter) keyword() string {
+	keyword, err := charmap.Windows874.NewEncoder().String(f.Keyword)
+	if err != nil {
+		return f.Keyword // Validate rejects it
+	}
+	return keyword
+}
+
+// setWindow adds the startPage and endPage the SMCE pagers put in their
+// links: windows of ten pages, each starting on the last page of the one
+// before (1-10, 10-19, 19-28, ...). The pager of the page served is drawn
+// from them.
+func setWindow(q url.Values, page int) {
+	if page < 1 {
+		return
+	}
+	start := 1 + (page-1)/9*9
+	q.Set("startPage", strconv.Itoa(start))
+	q.Set("endPage", strconv.Itoa(start+9))
+}
+
+// ProductPageURL returns the URL of one page of the product listing
+func (f Filter) ProductPageURL(pageSize, page int) string {
+	return ProductResultURL + "?" + f.ProductQuery(pageSize, page).Encode()
+}
+
+// CategoryPageURL returns the URL of one page of the enterprise listing
+func (f Filter) CategoryPageURL(pageSize, page int) string {
+	return CategoryURL + "?" + f.CategoryQuery(pageSize, page).Encode()
+}
+
+// ProductReferer is the search form URL a browser would come from
+func (f Filter) ProductReferer() string {
+	q := f.ProductQuery(0, 0)
+	q.Del("page_size")
+	q.Del("PAGE")
+	return ProductResultURL + "?" + q.Encode()
+}
+
+// CategoryReferer is the search form URL a browser would come from
+func (f Filter) CategoryReferer() string {
+	q := f.CategoryQuery(0, 0)
+	q.Del("page_size")
+	q.Del("PAGE")
+	return CategoryURL + "?" + q.Encode()
+}
+
+// Referer is the search form URL a browser would come from to pageURL, one
+// page of either listing. Retries use it when the filter that built pageURL
+// is gone.
+func Referer(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return BaseURL
+	}
+	q := u.Query()
+	for _, key := range []string{"page_size", "PAGE", "startPage", "endPage"} {
+		q.Del(key)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
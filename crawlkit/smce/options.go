@@ -0,0 +1,77 @@
+package smce
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// FormURL is the E-Catalog landing page that links every business type
+const FormURL = BaseURL + "ProductCategory/ProductCategory.php"
+
+// Option is one code/label pair from a search form
+type Option struct {
+	Code  string `json:"code"`
+	Label string `json:"label"`
+}
+
+// SelectOptions returns the options of the named select, skipping the
+// empty "all" entry every SMCE select starts with
+func SelectOptions(doc *goquery.Document, name string) []Option {
+	var options []Option
+	doc.Find(`select[name="` + name + `"] option`).Each(func(i int, s *goquery.Selection) {
+		code, _ := s.Attr("value")
+		code = strings.TrimSpace(code)
+		if code == "" {
+			return
+		}
+		options = append(options, Option{Code: code, Label: strings.Join(strings.Fields(s.Text()), " ")})
+	})
+	return options
+}
+
+// BusinessTypes returns the business types offered on the search form. The
+// business_type_id select is used when the page has one, otherwise the
+// category links carrying a business_type_id are collected.
+func BusinessTypes(doc *goquery.Document) []Option {
+	if options := SelectOptions(doc, "business_type_id"); len(options) > 0 {
+		return options
+	}
+
+	labels := map[string]string{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		u, err := url.Parse(strings.TrimSpace(href))
+		if err != nil {
+			return
+		}
+		code := u.Query().Get("business_type_id")
+		if code == "" {
+			return
+		}
+		label := strings.Join(strings.Fields(s.Text()), " ")
+		if label == "" {
+			label, _ = s.Find("img").Attr("alt")
+		}
+		if labels[code] == "" {
+			labels[code] = strings.TrimSpace(label)
+		}
+	})
+
+	options := make([]Option, 0, len(labels))
+	for code, label := range labels {
+		options = append(options, Option{Code: code, Label: label})
+	}
+	sort.Slice(options, func(i, j int) bool { return lessCode(options[i].Code, options[j].Code) })
+	return options
+}
+
+// lessCode orders numeric codes by value, so "2" sorts before "10"
+func lessCode(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,36 @@
+package smce
+
+import (
+	"regexp"
+	"strings"
+)
+
+// extension matches an extension after a number, such as " ต่อ 12"
+var extension = regexp.MustCompile(`\s*(ต่อ|ext\.?|#)\s*[0-9]+$`)
+
+// E164 writes a Thai phone or fax number as the site shows it, such as
+// "0875012908" or "02-123 4567 ต่อ 12", in E.164 form: "+66875012908". An
+// extension is dropped. A blank, "-" or anything that is not one Thai
+// number gives "".
+func E164(value string) string {
+	value = extension.ReplaceAllString(strings.TrimSpace(value), "")
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+			return -1
+		case r == '+':
+			return r
+		}
+		return 'x'
+	}, value)
+	switch {
+	case strings.HasPrefix(digits, "+66") && len(digits) >= 11 && len(digits) <= 12 && !strings.ContainsAny(digits[1:], "+x"):
+		return digits
+	case strings.HasPrefix(digits, "0") && len(digits) >= 9 && len(digits) <= 10 && !strings.ContainsAny(digits, "+x"):
+		// landlines have 9 digits, mobiles 10, both with the trunk prefix 0
+		return "+66" + digits[1:]
+	}
+	return ""
+}
@@ -0,0 +1,290 @@
+package smce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// LocationURL serves the dependent province and amphur option lists
+const LocationURL = BaseURL + "smce1/ajax/dd_location.php"
+
+// CodeTablesSchema is bumped whenever the CodeTables layout changes
+const CodeTablesSchema = 1
+
+// CodeTables are the region, province, amphur and business type codes the
+// SMCE search forms accept. ProductC_Result.php calls them select_region,
+// select_province and select_amphur, SmceCategory.php region_id,
+// province_id and amphur_id; the codes are the same.
+type CodeTables struct {
+	Schema        int        `json:"schema"`
+	Version       string     `json:"version"` // วันที่ดึง + hash ของตาราง
+	FetchedAt     time.Time  `json:"fetched_at"`
+	Regions       []Region   `json:"regions"`
+	Provinces     []Province `json:"provinces"`
+	BusinessTypes []Option   `json:"business_types"`
+}
+
+// Region is a เขต with the codes of its provinces
+type Region struct {
+	Option
+	Provinces []string `json:"provinces"`
+}
+
+// Province is a จังหวัด with its amphurs
+type Province struct {
+	Option
+	Region  string   `json:"region,omitempty"`
+	Amphurs []Option `json:"amphurs"`
+}
+
+// Fetcher downloads and parses one page, already decoded to UTF-8
+type Fetcher func(pageURL string) (*goquery.Document, error)
+
+// FetchCodeTables crawls the search form and every dependent dropdown
+func FetchCodeTables(fetch Fetcher, logf func(format string, args ...any)) (*CodeTables, error) {
+	form, err := fetch(ProductResultURL)
+	if err != nil {
+		return nil, fmt.Errorf("search form: %v", err)
+	}
+	tables := &CodeTables{Schema: CodeTablesSchema, FetchedAt: time.Now()}
+
+	provinces := map[string]*Province{}
+	var order []string
+	addProvince := func(option Option, region string) {
+		if p, ok := provinces[option.Code]; ok {
+			if p.Region == "" {
+				p.Region = region
+			}
+			return
+		}
+		provinces[option.Code] = &Province{Option: option, Region: region}
+		order = append(order, option.Code)
+	}
+
+	for _, option := range SelectOptions(form, "select_region") {
+		doc, err := fetch(LocationURL + "?" + url.Values{"region_id": {option.Code}, "location": {"province"}}.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("provinces of region %s: %v", option.Code, err)
+		}
+		region := Region{Option: option}
+		for _, province := range Options(doc) {
+			region.Provinces = append(region.Provinces, province.Code)
+			addProvince(province, option.Code)
+		}
+		tables.Regions = append(tables.Regions, region)
+		logf("region %s: %d provinces", option.Code, len(region.Provinces))
+	}
+	// จังหวัดที่ไม่อยู่ในเขตใดยังต้องมีในตาราง
+	for _, option := range SelectOptions(form, "select_province") {
+		addProvince(option, "")
+	}
+
+	for _, code := range order {
+		province := provinces[code]
+		doc, err := fetch(LocationURL + "?" + url.Values{"province_id": {code}, "location": {"amphur"}}.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("amphurs of province %s: %v", code, err)
+		}
+		province.Amphurs = Options(doc)
+		tables.Provinces = append(tables.Provinces, *province)
+		logf("province %s %s: %d amphurs", code, province.Label, len(province.Amphurs))
+	}
+
+	landing, err := fetch(FormURL)
+	if err != nil {
+		return nil, fmt.Errorf("business types: %v", err)
+	}
+	tables.BusinessTypes = BusinessTypes(landing)
+
+	tables.Version = tables.FetchedAt.Format("2006-01-02") + "-" + tables.hash()
+	return tables, nil
+}
+
+// Options returns every non-empty option in a document, used for the bare
+// <option> fragments dd_location.php returns
+func Options(doc *goquery.Document) []Option {
+	var options []Option
+	doc.Find("option").Each(func(i int, s *goquery.Selection) {
+		code, _ := s.Attr("value")
+		code = strings.TrimSpace(code)
+		if code == "" {
+			return
+		}
+		options = append(options, Option{Code: code, Label: strings.Join(strings.Fields(s.Text()), " ")})
+	})
+	return options
+}
+
+// hash identifies the table contents, so two crawls that found the same
+// codes share a version suffix
+func (t *CodeTables) hash() string {
+	data, _ := json.Marshal([]any{t.Regions, t.Provinces, t.BusinessTypes})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Save writes the tables as indented JSON
+func (t *CodeTables) Save(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// LoadCodeTables reads tables written by Save
+func LoadCodeTables(path string) (*CodeTables, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tables CodeTables
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if tables.Schema != CodeTablesSchema {
+		return nil, fmt.Errorf("%s: schema %d, expected %d (re-run refdata)", path, tables.Schema, CodeTablesSchema)
+	}
+	return &tables, nil
+}
+
+// Region returns the region with the given code
+func (t *CodeTables) Region(code string) (Region, bool) {
+	for _, region := range t.Regions {
+		if region.Code == code {
+			return region, true
+		}
+	}
+	return Region{}, false
+}
+
+// Province returns the province with the given code
+func (t *CodeTables) Province(code string) (Province, bool) {
+	for _, province := range t.Provinces {
+		if province.Code == code {
+			return province, true
+		}
+	}
+	return Province{}, false
+}
+
+// Amphur returns an amphur of the given province, or of any province when
+// provinceCode is empty
+func (t *CodeTables) Amphur(provinceCode, code string) (Option, Province, bool) {
+	for _, province := range t.Provinces {
+		if provinceCode != "" && province.Code != provinceCode {
+			continue
+		}
+		for _, amphur := range province.Amphurs {
+			if amphur.Code == code {
+				return amphur, province, true
+			}
+		}
+	}
+	return Option{}, Province{}, false
+}
+
+// BusinessType returns the business type with the given code
+func (t *CodeTables) BusinessType(code string) (Option, bool) {
+	for _, option := range t.BusinessTypes {
+		if option.Code == code {
+			return option, true
+		}
+	}
+	return Option{}, false
+}
+
+// Check reports codes in f that the tables do not know, and an amphur or
+// province that does not belong to the chosen province or region
+func (t *CodeTables) Check(f Filter) error {
+	if f.Region != "" {
+		if _, ok := t.Region(f.Region); !ok {
+			return fmt.Errorf("--region %s is not a known region", f.Region)
+		}
+	}
+	if f.Province != "" {
+		province, ok := t.Province(f.Province)
+		if !ok {
+			return fmt.Errorf("--province %s is not a known province", f.Province)
+		}
+		if f.Region != "" && province.Region != "" && province.Region != f.Region {
+			return fmt.Errorf("--province %s (%s) is in region %s, not %s", f.Province, province.Label, province.Region, f.Region)
+		}
+	}
+	if f.Amphur != "" {
+		if _, _, ok := t.Amphur(f.Province, f.Amphur); !ok {
+			if f.Province != "" {
+				return fmt.Errorf("--amphur %s is not an amphur of province %s", f.Amphur, f.Province)
+			}
+			return fmt.Errorf("--amphur %s is not a known amphur", f.Amphur)
+		}
+	}
+	if f.BusinessType != "" && len(t.BusinessTypes) > 0 {
+		if _, ok := t.BusinessType(f.BusinessType); !ok {
+			return fmt.Errorf("--business-type %s is not a known business type", f.BusinessType)
+		}
+	}
+	return nil
+}
+
+// Describe labels the filter for logs, e.g. "จังหวัด ราชบุรี (70)"
+func (t *CodeTables) Describe(f Filter) string {
+	var parts []string
+	if region, ok := t.Region(f.Region); ok {
+		parts = append(parts, fmt.Sprintf("%s (%s)", region.Label, region.Code))
+	}
+	if province, ok := t.Province(f.Province); ok {
+		parts = append(parts, fmt.Sprintf("จังหวัด %s (%s)", province.Label, province.Code))
+	}
+	if amphur, _, ok := t.Amphur(f.Province, f.Amphur); ok {
+		parts = append(parts, fmt.Sprintf("อำเภอ %s (%s)", amphur.Label, amphur.Code))
+	}
+	if businessType, ok := t.BusinessType(f.BusinessType); ok {
+		parts = append(parts, fmt.Sprintf("%s (%s)", businessType.Label, businessType.Code))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Locate finds the province and amphur named in a Thai address, e.g.
+// "... อำเภอจอมบึง จังหวัดราชบุรี". Bangkok addresses use เขต for the amphur
+// and often leave out จังหวัด. The longest matching amphur wins so that
+// "อำเภอบ้านโป่ง" is not taken for a shorter name it starts with.
+func (t *CodeTables) Locate(address string) (Province, Option, bool) {
+	address = strings.Join(strings.Fields(address), " ")
+	for _, province := range t.Provinces {
+		if !containsPlace(address, []string{"จังหวัด", "จ."}, province.Label) &&
+			!(province.Code == "10" && strings.Contains(address, "กรุงเทพ")) {
+			continue
+		}
+		var found Option
+		for _, amphur := range province.Amphurs {
+			if len(amphur.Label) > len(found.Label) && containsPlace(address, []string{"อำเภอ", "อ.", "เขต"}, amphur.Label) {
+				found = amphur
+			}
+		}
+		return province, found, true
+	}
+	return Province{}, Option{}, false
+}
+
+// containsPlace reports whether name appears after any of the prefixes,
+// with or without a space in between
+func containsPlace(address string, prefixes []string, name string) bool {
+	for _, prefix := range prefixes {
+		if strings.Contains(address, prefix+name) || strings.Contains(address, prefix+" "+name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package smce
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crawlkit/block"
+	"crawlkit/fetcherr"
+	"crawlkit/robots"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// SessionCookie is the cookie the SMCE search pages keep state in
+const SessionCookie = "PHPSESSID"
+
+// Where a manual cookie override is read from. The value is a Cookie header,
+// e.g. "PHPSESSID=abc123"; it must never be committed.
+const (
+	CookieEnv         = "SMCE_COOKIE"
+	CookieFileEnv     = "SMCE_COOKIE_FILE"
+	DefaultCookieFile = ".secrets/smce_cookie"
+)
+
+// Session is a cookie jar backed SMCE browsing session. The first request
+// visits the landing page to get a PHPSESSID, and an expired session is
+// re-established once per request before giving up.
+type Session struct {
+	Client *http.Client
+	Logf   func(format string, args ...any)
+	Robots *robots.Checker   // nil to skip robots.txt
+	Block  *block.Detector   // nil to skip block page detection
+	Header map[string]string // request profile headers, sent when starting a session
+
+	override []*http.Cookie
+	ready    bool
+}
+
+// NewSession returns a session that has not been bootstrapped yet. A cookie
+// override from the environment or the secrets file is picked up here.
+func NewSession(timeout time.Duration) (*Session, error) {
+	s := &Session{
+		Client: &http.Client{Timeout: timeout},
+		Logf:   log.Printf,
+	}
+	override, source, err := cookieOverride()
+	if err != nil {
+		return nil, err
+	}
+	if len(override) > 0 {
+		s.override = override
+		s.Logf("Using session cookie from %s", source)
+	}
+	if err := s.resetJar(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Bootstrap starts a fresh session: the override cookie if one is configured
+// and unused, otherwise a new PHPSESSID from the landing page. Failures come
+// back as *fetcherr.Error for the landing page.
+func (s *Session) Bootstrap(ctx context.Context) error {
+	if err := s.resetJar(); err != nil {
+		return err
+	}
+	base, _ := url.Parse(BaseURL)
+
+	if len(s.override) > 0 {
+		s.Client.Jar.SetCookies(base, s.override)
+		s.override = nil // ใช้ได้ครั้งเดียว ถ้าหมดอายุจะขอ session ใหม่จากหน้าแรก
+		s.ready = true
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", BaseURL, nil)
+	if err != nil {
+		return err
+	}
+	// the landing page is opened directly, so it gets no referer
+	for key, value := range s.Header {
+		if key != "referer" {
+			req.Header.Set(key, value)
+		}
+	}
+	resp, err := s.Robots.Do(s.Client, req)
+	if err != nil {
+		return fetcherr.Fetch(fetcherr.StageFetch, BaseURL, fmt.Errorf("session bootstrap: %w", err))
+	}
+	resp.Body.Close()
+
+	for _, cookie := range s.Client.Jar.Cookies(base) {
+		if cookie.Name == SessionCookie {
+			s.ready = true
+			s.Logf("Started new SMCE session")
+			return nil
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fetcherr.Status(BaseURL, resp.StatusCode)
+	}
+	return fetcherr.Structure(BaseURL, "session bootstrap: no %s cookie", SessionCookie)
+}
+
+// Do sends a request within the session. A request that gets redirected to
+// another page means the session has expired; it is re-established and the
+// request sent once more. The session shares the request's context.
+func (s *Session) Do(req *http.Request) (*http.Response, error) {
+	if !s.ready {
+		if err := s.Bootstrap(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := s.Robots.Do(s.Client, req.Clone(req.Context()))
+	if err != nil || !redirected(req, resp) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	s.Logf("Session expired (redirected to %s), starting a new one", resp.Request.URL)
+	if err := s.Bootstrap(req.Context()); err != nil {
+		return nil, err
+	}
+	return s.Robots.Do(s.Client, req.Clone(req.Context()))
+}
+
+// FetchListing GETs a search result page and decodes it from TIS-620. A page
+// without result rows may also mean an expired session, so it is fetched
+// again on a new session before being returned as is. Failures, including a
+// session that cannot be started, come back as *fetcherr.Error.
+func (s *Session) FetchListing(ctx context.Context, pageURL string, header map[string]string) (*goquery.Document, error) {
+	doc, err := s.fetch(ctx, pageURL, header)
+	if err != nil || doc.Find("table.table tbody tr").Length() > 0 {
+		return doc, err
+	}
+
+	s.Logf("No rows on %s, retrying on a new session", pageURL)
+	if err := s.Bootstrap(ctx); err != nil {
+		return nil, err
+	}
+	return s.fetch(ctx, pageURL, header)
+}
+
+func (s *Session) fetch(ctx context.Context, pageURL string, header map[string]string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.Do(req)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageFetch, pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := s.Block.ReadBody(pageURL, resp)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageRead, pageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetcherr.Status(pageURL, resp.StatusCode)
+	}
+
+	reader := transform.NewReader(bytes.NewReader(body), charmap.Windows874.NewDecoder()) // TIS-620 -> UTF-8
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, fetcherr.Decode(pageURL, err)
+	}
+	return doc, nil
+}
+
+func (s *Session) resetJar() error {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	s.Client.Jar = jar
+	s.ready = false
+	return nil
+}
+
+// redirected reports whether resp ended up on a different page than req
+func redirected(req *http.Request, resp *http.Response) bool {
+	return resp.Request != nil && resp.Request.URL.Path != req.URL.Path
+}
+
+// cookieOverride reads the Cookie header from $SMCE_COOKIE, or from the file
+// named by $SMCE_COOKIE_FILE (default .secrets/smce_cookie) when it exists
+func cookieOverride() ([]*http.Cookie, string, error) {
+	if value := strings.TrimSpace(os.Getenv(CookieEnv)); value != "" {
+		cookies, err := http.ParseCookie(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("$%s: %v", CookieEnv, err)
+		}
+		return cookies, "$" + CookieEnv, nil
+	}
+
+	path := os.Getenv(CookieFileEnv)
+	if path == "" {
+		path = DefaultCookieFile
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && os.Getenv(CookieFileEnv) == "" {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return nil, "", nil
+	}
+	cookies, err := http.ParseCookie(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", path, err)
+	}
+	return cookies, path, nil
+}
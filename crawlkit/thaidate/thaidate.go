@@ -0,0 +1,54 @@
+// Package thaidate reads the dates Thai sites print, either in Thai with a
+// Buddhist era year or in English.
+package thaidate
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// months maps full and abbreviated Thai month names to their number
+var months = map[string]time.Month{
+	"มกราคม": time.January, "ม.ค.": time.January,
+	"กุมภาพันธ์": time.February, "ก.พ.": time.February,
+	"มีนาคม": time.March, "มี.ค.": time.March,
+	"เมษายน": time.April, "เม.ย.": time.April,
+	"พฤษภาคม": time.May, "พ.ค.": time.May,
+	"มิถุนายน": time.June, "มิ.ย.": time.June,
+	"กรกฎาคม": time.July, "ก.ค.": time.July,
+	"สิงหาคม": time.August, "ส.ค.": time.August,
+	"กันยายน": time.September, "ก.ย.": time.September,
+	"ตุลาคม": time.October, "ต.ค.": time.October,
+	"พฤศจิกายน": time.November, "พ.ย.": time.November,
+	"ธันวาคม": time.December, "ธ.ค.": time.December,
+}
+
+// Parse reads a Thai date ("03 ธันวาคม 2572", Buddhist era) or an English
+// one ("03 December 2029") as midnight in loc
+func Parse(value string, loc *time.Location) (time.Time, bool) {
+	value = strings.Join(strings.Fields(value), " ")
+	if t, err := time.ParseInLocation("02 January 2006", value, loc); err == nil {
+		return t, true
+	}
+	parts := strings.Fields(value)
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	day, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return time.Time{}, false
+	}
+	month, ok := months[parts[1]]
+	if !ok {
+		return time.Time{}, false
+	}
+	year, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	if year > 2400 {
+		year -= 543 // พ.ศ. -> ค.ศ.
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc), true
+}
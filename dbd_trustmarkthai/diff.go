@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+
+	"crawlkit/diff"
+)
+
+// diffSpec จับคู่รายการระหว่างสองรอบด้วย token data ของ popup ถ้ามี ไม่เช่นนั้น
+// ใช้เลขประจำตัวกับชื่อร้านค้าออนไลน์ เพราะเลขประจำตัวที่เว็บแสดงถูกปิดบางหลัก
+// และซ้ำกันได้ ส่วน no เป็นแค่ลำดับในหน้า
+var diffSpec = diff.Spec{
+	Noun:   "businesses",
+	Key:    []string{"national_id", "online_store_name"},
+	Token:  "data_token",
+	Ignore: []string{"no"},
+}
+
+// runDiff เปรียบเทียบผลลัพธ์สองรอบ และเขียนรายการที่เพิ่ม ถูกลบ หรือเปลี่ยนไปเป็น NDJSON
+//
+//	go run . diff --before last-week.json [--after output.json] [--output diff.ndjson]
+func runDiff(args []string) {
+	summary, err := diff.RunCommand(args, diffSpec, "output.json")
+	if err != nil {
+		log.Fatalf("ไม่สามารถเปรียบเทียบไฟล์: %v", err)
+	}
+	summary.LogReport(log.Printf)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runExpiring lists businesses whose DBD Registered mark lapses within the
+// given window, soonest first.
+//
+//	go run . expiring --within 30d [--input output.json] [--json]
+func runExpiring(args []string) {
+	fs := flag.NewFlagSet("expiring", flag.ExitOnError)
+	within := fs.String("within", "30d", "time window, e.g. 30d, 2w or 720h")
+	input := fs.String("input", "output.json", "crawl output to read")
+	asJSON := fs.Bool("json", false, "print matching records as JSON")
+	fs.Parse(args)
+
+	window, err := parseWindow(*within)
+	if err != nil {
+		log.Fatalf("ค่า --within ไม่ถูกต้อง: %v", err)
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		log.Fatalf("ไม่สามารถอ่านไฟล์ %s: %v", *input, err)
+	}
+	var all []BusinessInfo
+	if err := json.Unmarshal(data, &all); err != nil {
+		log.Fatalf("ไม่สามารถแปลง JSON จาก %s: %v", *input, err)
+	}
+
+	now := time.Now()
+	deadline := startOfDay(now).Add(window)
+
+	expiring := []BusinessInfo{}
+	for _, info := range all {
+		// คำนวณใหม่ทุกครั้ง เพราะสถานะขึ้นกับวันที่รันและไฟล์เก่าอาจยังไม่มี lifecycle
+		info.Lifecycle = buildLifecycle(info, now)
+		if info.Lifecycle.Status != StatusActive || info.Lifecycle.Expires.After(deadline) {
+			continue
+		}
+		expiring = append(expiring, info)
+	}
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].Lifecycle.Expires.Before(expiring[j].Lifecycle.Expires.Time)
+	})
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(expiring); err != nil {
+			log.Fatalf("ไม่สามารถแปลงข้อมูลเป็น JSON: %v", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "EXPIRES\tDAYS\tNATIONAL_ID\tBUSINESS\tOWNER\tSTORE")
+	for _, info := range expiring {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+			info.Lifecycle.Expires.Format(dateLayout),
+			info.Lifecycle.DaysToExpiry(now),
+			info.NationalID,
+			info.BusinessName,
+			info.OwnerName,
+			info.OnlineStoreName,
+		)
+	}
+	w.Flush()
+
+	log.Printf("พบ %d รายการที่จะหมดอายุภายใน %s", len(expiring), *within)
+}
+
+// parseWindow accepts day and week suffixes on top of time.ParseDuration
+func parseWindow(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"crawlkit/export"
+)
+
+// runExport เขียนผลการดึงข้อมูลเป็น CSV หรือ XLSX สำหรับเปิดใน Excel หรือเป็น Parquet
+//
+//	go run . export [--format csv|xlsx|parquet] [--input output.json] [--output export.csv] [--separator "; "]
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var opts export.Options
+	opts.AddFlags(fs, "output.json")
+	fs.Parse(args)
+
+	all, err := export.ReadRecords[BusinessInfo](opts.Input)
+	if err != nil {
+		log.Fatalf("ไม่สามารถอ่านไฟล์ %s: %v", opts.Input, err)
+	}
+	if opts.Format == "parquet" {
+		exportParquet(opts, all)
+		return
+	}
+
+	// ร้านค้าออนไลน์ของแต่ละรายการอยู่ในชีต stores ของไฟล์ XLSX
+	spec := export.Spec{Name: "businesses", Key: []string{"no", "national_id"}}
+	path, err := export.Write(opts, spec, all)
+	if err != nil {
+		log.Fatalf("ไม่สามารถส่งออกข้อมูล: %v", err)
+	}
+	log.Printf("ส่งออก %d รายการไปยัง %s", len(all), path)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"crawlkit/model"
+	"crawlkit/thaidate"
+)
+
+// LifecycleStatus is the certification state of a DBD Registered trustmark
+type LifecycleStatus string
+
+const (
+	StatusActive    LifecycleStatus = "active"
+	StatusExpired   LifecycleStatus = "expired"
+	StatusSuspended LifecycleStatus = "suspended"
+	StatusRevoked   LifecycleStatus = "revoked"
+	StatusUnknown   LifecycleStatus = "unknown"
+)
+
+// dateLayout is how Date values are written to JSON
+const dateLayout = "2006-01-02"
+
+// bangkok is fixed at UTC+7 so parsing does not depend on the system tzdata
+var bangkok = time.FixedZone("ICT", 7*60*60)
+
+// Date is a calendar date in Bangkok time, serialized as YYYY-MM-DD
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		d.Time = time.Time{}
+		return nil
+	}
+	t, err := time.ParseInLocation(dateLayout, s, bangkok)
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// Lifecycle is the typed view of the trustmark dates and status text
+type Lifecycle struct {
+	Status          LifecycleStatus `json:"status"`
+	FirstRegistered Date            `json:"first_registered"`
+	Renewed         Date            `json:"renewed"`
+	Expires         Date            `json:"expires"`
+}
+
+// DaysToExpiry returns whole days from now until expiry (negative once lapsed)
+func (l Lifecycle) DaysToExpiry(now time.Time) int {
+	return int(l.Expires.Sub(startOfDay(now)).Hours() / 24)
+}
+
+// buildLifecycle parses the trustmark dates of a record and works out its
+// status as of now. Suspension and revocation can only come from the status
+// text on the site, expiry is decided by the expiration date. The English
+// "registered" date on the site is the last renewal, not the first
+// registration, so it is no fallback for FirstRegistered.
+func buildLifecycle(info BusinessInfo, now time.Time) Lifecycle {
+	l := Lifecycle{
+		FirstRegistered: parseDate(info.DBDRegisteredDate),
+		Renewed:         parseDate(info.DBDRenewalDate),
+		Expires:         parseDate(info.DBDExpirationDate, info.ExpirationDateEN),
+	}
+
+	status := LifecycleStatus(model.StatusFromText(info.TrustmarkStatus))
+	switch {
+	case status != "":
+		l.Status = status
+	case l.Expires.IsZero():
+		l.Status = StatusUnknown
+	case !startOfDay(now).Before(l.Expires.Time):
+		l.Status = StatusExpired
+	default:
+		l.Status = StatusActive
+	}
+	return l
+}
+
+// parseDate returns the first of the given values that parses as either a
+// Thai date ("03 ธันวาคม 2572", Buddhist era) or an English one ("03 December 2029").
+func parseDate(values ...string) Date {
+	for _, value := range values {
+		if t, ok := thaidate.Parse(value, bangkok); ok {
+			return Date{t}
+		}
+	}
+	return Date{}
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.In(bangkok)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, bangkok)
+}
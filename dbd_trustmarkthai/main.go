@@ -1,35 +1,60 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
+
+	"crawlkit/block"
+	"crawlkit/deadletter"
+	"crawlkit/diff"
+	"crawlkit/fetcherr"
+	"crawlkit/lake"
+	"crawlkit/migrate"
+	"crawlkit/paging"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/shutdown"
+	"crawlkit/sink"
 
 	"github.com/PuerkitoBio/goquery"
 )
 
 // BusinessInfo represents the structure for the extracted data
 type BusinessInfo struct {
-	No                  int    `json:"no"`
-	OwnerName           string `json:"owner_name"`
-	BusinessName        string `json:"business_name"`
-	NationalID          string `json:"national_id"`
-	OnlineStoreName     string `json:"online_store_name"`
-	Platform            string `json:"platform"`
-	BusinessTypeTH      string `json:"business_type_th"`
-	BusinessTypeEN      string `json:"business_type_en"`
-	AddressTH           string `json:"address_th"`
-	AddressEN           string `json:"address_en"`
-	TrustmarkStatus     string `json:"trustmark_status"`
-	RegistrationDate    string `json:"registration_date"`
-	DBDRegisteredDate   string `json:"dbd_registered_date"`
-	DBDRenewalDate      string `json:"dbd_renewal_date"`
-	DBDExpirationDate   string `json:"dbd_expiration_date"`
-	RegisteredDateEN    string `json:"registered_date_en"`
-	ExpirationDateEN    string `json:"expiration_date_en"`
+	No                 int       `json:"no"`
+	DataToken          string    `json:"data_token,omitempty"`
+	OwnerName          string    `json:"owner_name"`
+	BusinessName       string    `json:"business_name"`
+	NationalID         string    `json:"national_id"`
+	EntityType         string    `json:"entity_type"`
+	JuristicID         string    `json:"juristic_id,omitempty"`
+	JuristicOfficeCode string    `json:"juristic_office_code,omitempty"`
+	NationalIDMask     string    `json:"national_id_mask,omitempty"`
+	NationalIDChecksum string    `json:"national_id_checksum,omitempty"`
+	OnlineStoreName    string    `json:"online_store_name"`
+	Platform           string    `json:"platform"`
+	Stores             []Store   `json:"stores"`
+	BusinessTypeTH     string    `json:"business_type_th"`
+	BusinessTypeEN     string    `json:"business_type_en"`
+	AddressTH          string    `json:"address_th"`
+	AddressEN          string    `json:"address_en"`
+	TrustmarkStatus    string    `json:"trustmark_status"`
+	RegistrationDate   string    `json:"registration_date"`
+	DBDRegisteredDate  string    `json:"dbd_registered_date"`
+	DBDRenewalDate     string    `json:"dbd_renewal_date"`
+	DBDExpirationDate  string    `json:"dbd_expiration_date"`
+	RegisteredDateEN   string    `json:"registered_date_en"`
+	ExpirationDateEN   string    `json:"expiration_date_en"`
+	Lifecycle          Lifecycle `json:"lifecycle"`
 }
 
 // cleanField removes extra spaces and formats text properly
@@ -39,52 +64,58 @@ func cleanField(value string) string {
 
 // fetchData retrieves the data from a specific URL and assigns No.
 func fetchData(url string, no int) (BusinessInfo, error) {
-	resp, err := http.Get(url)
+	resp, err := get(url)
 	if err != nil {
-		return BusinessInfo{}, fmt.Errorf("ไม่สามารถเข้าถึงหน้าเว็บได้: %v", err)
+		return BusinessInfo{}, fetcherr.Fetch(fetcherr.StageFetch, url, err)
 	}
 	defer resp.Body.Close()
 
+	// หน้า captcha / WAF มักตอบ 200 จึงต้องตรวจเนื้อหาก่อน
+	body, err := blockDetector.ReadBody(url, resp)
+	if err != nil {
+		return BusinessInfo{}, fetcherr.Fetch(fetcherr.StageRead, url, err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return BusinessInfo{}, fmt.Errorf("การเข้าถึงหน้าเว็บล้มเหลว: HTTP %d", resp.StatusCode)
+		return BusinessInfo{}, fetcherr.Status(url, resp.StatusCode)
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return BusinessInfo{}, fmt.Errorf("ไม่สามารถแปลง HTML เป็น Document: %v", err)
+		return BusinessInfo{}, fetcherr.Decode(url, err)
 	}
 
-	info := BusinessInfo{No: no}
+	// popup ที่ไม่มีตารางข้อมูลคือรายการที่ไม่มีอยู่แล้ว
+	rows := doc.Find("table tr")
+	if rows.Length() == 0 {
+		return BusinessInfo{}, fetcherr.NotFound(url, "no record table")
+	}
+
+	info := BusinessInfo{No: no, DataToken: dataToken(url)}
 
-	doc.Find("table tr").Each(func(i int, s *goquery.Selection) {
+	rows.Each(func(i int, s *goquery.Selection) {
 		header := cleanField(s.Find("td.text-right").Text())
 		value := cleanField(s.Find("td:not(.text-right)").Text())
 
 		switch header {
 		case "เลขประจำตัวประชาชน/เลขทะเบียนนิติบุคคล (Thai national Id/Juristic person Id) :":
 			info.NationalID = value
+			id := parseNationalID(value)
+			info.EntityType = string(id.EntityType)
+			info.JuristicID = id.JuristicID()
+			info.JuristicOfficeCode = id.OfficeCode
+			info.NationalIDMask = id.MaskPattern
+			info.NationalIDChecksum = id.Checksum
 		case "ชื่อผู้ประกอบการ :":
 			info.OwnerName = value
 		case "ชื่อที่ใช้ในการประกอบพาณิชยกิจ :":
 			info.BusinessName = value
 		case "ชื่อร้านค้าออนไลน์ (Online store) :":
 			info.OnlineStoreName = value
-			if strings.Contains(strings.ToLower(value), "facebook") {
-				info.Platform = "Facebook"
-			} else if strings.Contains(strings.ToLower(value), "shopee") {
-				info.Platform = "Shopee"
-			} else if strings.Contains(strings.ToLower(value), "lazada") {
-				info.Platform = "Lazada"
-			} else if strings.Contains(strings.ToLower(value), "line") {
-				info.Platform = "LINE"
-			} else if strings.Contains(strings.ToLower(value), "instagram") {
-				info.Platform = "Instagram"
-			} else if strings.Contains(strings.ToLower(value), "twitter") {
-				info.Platform = "Twitter"
-			} else if strings.Contains(strings.ToLower(value), "tiktok") {
-				info.Platform = "TikTok"
+			info.Stores = parseStores(value)
+			if len(info.Stores) > 0 {
+				info.Platform = info.Stores[0].Platform
 			} else {
-				info.Platform = "Website"
+				info.Platform = textPlatform(value)
 			}
 		case "ประเภทธุรกิจ :":
 			info.BusinessTypeTH = value
@@ -111,82 +142,302 @@ func fetchData(url string, no int) (BusinessInfo, error) {
 		}
 	})
 
+	info.Lifecycle = buildLifecycle(info, time.Now())
+
 	return info, nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "crawl":
+			crawl(os.Args[2:])
+			return
+		case "expiring":
+			runExpiring(os.Args[2:])
+			return
+		case "retry-failed":
+			runRetryFailed(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "schema":
+			runSchema(os.Args[2:])
+			return
+		case "validate":
+			runValidate(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "migrate":
+			report, err := migrate.RunCommand(os.Args[2:], "output.json")
+			if err != nil {
+				log.Fatalf("ไม่สามารถแปลงไฟล์: %v", err)
+			}
+			report.LogReport(log.Printf)
+			return
+		case "import-profile":
+			message, err := profile.RunImport(os.Args[2:], os.Stdin)
+			if err != nil {
+				log.Fatalf("ไม่สามารถนำเข้า profile: %v", err)
+			}
+			log.Println(message)
+			return
+		}
+	}
+	crawl(os.Args[1:])
+}
+
+// searchURL is the search form every page is reached from, the referer of
+// the request profile
+const searchURL = "https://trustmarkthai.com/th/search"
+
+// get downloads pageURL with the headers of the request profile
+func get(pageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range requestHeader {
+		req.Header.Set(key, value)
+	}
+	return robotsChecker.Do(robotsChecker.Client, req)
+}
+
+// fetchSearchPage downloads one page of search results
+func fetchSearchPage(pageURL string) (*goquery.Document, error) {
+	resp, err := get(pageURL)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageFetch, pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := blockDetector.ReadBody(pageURL, resp)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageRead, pageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetcherr.Status(pageURL, resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fetcherr.Decode(pageURL, err)
+	}
+	return doc, nil
+}
+
+// requestHeader ส่งไปกับทุก request และใช้ user-agent ของมันตรวจ robots.txt
+var requestHeader map[string]string
+
+// robotsChecker ตรวจ robots.txt และเว้นระยะตาม Crawl-delay ของแต่ละ host
+var robotsChecker *robots.Checker
+
+// blockDetector หยุดพักเมื่อเจอหน้า captcha / WAF
+var blockDetector *block.Detector
+
+// deadLetters เก็บ URL ที่ดึงไม่สำเร็จไว้ให้คำสั่ง retry-failed
+var deadLetters *deadletter.Queue
+
+// shutdownSignal หยุดรับงานใหม่เมื่อกด Ctrl-C และยกเลิก request ที่ค้างเมื่อพ้น grace period
+var shutdownSignal *shutdown.Signal
+
+// sqliteDB เก็บทุกรายการลง SQLite เมื่อกำหนด --sqlite (nil = ไม่ใช้)
+var sqliteDB *sink.DB
+
+// fetchOptions are the flags shared by every command that downloads pages
+type fetchOptions struct {
+	profile    profile.Selection
+	robots     robots.Options
+	proxy      proxy.Options
+	block      block.Options
+	deadLetter deadletter.Options
+	shutdown   shutdown.Options
+	sink       sink.Options
+	lake       lake.Options
+}
+
+func (o *fetchOptions) addFlags(fs *flag.FlagSet) {
+	o.profile.AddFlags(fs)
+	o.robots.AddFlags(fs)
+	o.proxy.AddFlags(fs)
+	o.block.AddFlags(fs)
+	o.deadLetter.AddFlags(fs)
+	o.shutdown.AddFlags(fs)
+	o.sink.AddFlags(fs)
+	o.lake.AddFlags(fs)
+}
+
+// setup prepares requestHeader, robotsChecker, blockDetector, deadLetters,
+// shutdownSignal, sqliteDB and businessLake and returns the proxy pool for
+// its end-of-run report. The caller closes shutdownSignal and sqliteDB and
+// calls closeParquet. run names the Parquet part files.
+func (o *fetchOptions) setup(run string) *proxy.Pool {
+	requestProfile, err := o.profile.Profile()
+	if err != nil {
+		log.Fatalf("request profile ไม่ถูกต้อง: %v", err)
+	}
+	requestHeader = requestProfile.Header(searchURL)
+	robotsChecker = robots.NewChecker(requestProfile.UserAgent, o.robots)
+
+	// ทุก request (รวมถึง robots.txt) วิ่งผ่าน proxy ที่กำหนดใน --proxies
+	proxyPool, err := o.proxy.Pool()
+	if err != nil {
+		log.Fatalf("รายการ proxy ไม่ถูกต้อง: %v", err)
+	}
+	robotsChecker.Client = proxyPool.Client(30 * time.Second)
+	blockDetector = block.NewDetector(o.block)
+	deadLetters = deadletter.New(o.deadLetter)
+	shutdownSignal = shutdown.Listen(o.shutdown)
+	blockDetector.Sleep = shutdownSignal.Sleep
+	if sqliteDB, err = sink.Open(o.sink); err != nil {
+		log.Fatalf("ไม่สามารถเปิดฐานข้อมูล SQLite: %v", err)
+	}
+	businessLake = lake.Open[businessRow](o.lake, "businesses", run)
+	crawlDate = time.Now()
+	return proxyPool
+}
+
+// crawl walks the trustmarkthai.com search pages and saves every record to output.json
+func crawl(args []string) {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	var fetch fetchOptions
+	var changes diff.Options
+	fetch.addFlags(fs)
+	changes.AddFlags(fs)
+	fs.Parse(args)
+	proxyPool := fetch.setup("crawl")
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer closeParquet()
+	defer proxyPool.LogReport(log.Printf)
+	defer deadLetters.LogReport(log.Printf)
+	defer sqliteDB.LogReport(log.Printf)
+
+	// ผลลัพธ์รอบก่อนต้องอ่านไว้ก่อน เพราะจะถูกเขียนทับตอนจบ
+	previous, err := changes.Previous("output.json", diffSpec)
+	if err != nil {
+		log.Fatalf("ไม่สามารถอ่านผลลัพธ์รอบก่อน: %v", err)
+	}
+
 	// Base URL ของหน้าแรก
-	baseURL := "https://trustmarkthai.com/th/search?page=%d"
+	baseURL := searchURL + "?page=%d"
 
 	// Slice สำหรับเก็บผลลัพธ์ทั้งหมด
 	var allData []BusinessInfo
 
-	// กำหนดจำนวนหน้าที่จะดึงข้อมูล
-	totalPages := 106150 // ปรับตามจำนวนหน้าที่ต้องการ
+	// pager แสดงเลขหน้าเพียงบางส่วน เลขหน้าสูงสุดจึงอ่านใหม่ทุกหน้า และหยุดเมื่อ
+	// เจอหน้าว่างหรือหน้าที่ซ้ำกับหน้าก่อนหน้า ซึ่งแปลว่าเลยหน้าสุดท้ายไปแล้ว
+	tracker := paging.NewTracker("page", 0)
 
 	// ลำดับรายการเริ่มต้น
 	no := 1
 
+	// หน้าสุดท้ายที่ดึงครบทุกรายการ สำหรับ checkpoint
+	donePage := 0
+
 	// วนลูปดึงข้อมูลจากแต่ละหน้า
-	for page := 1; page <= totalPages; page++ {
+	for page := 1; tracker.More(page); page++ {
+		// กด Ctrl-C แล้ว: ไม่เริ่มหน้าใหม่ แต่ยังบันทึกข้อมูลที่ได้มาแล้ว
+		if shutdownSignal.Stopping() {
+			log.Printf("หยุดก่อนหน้า %d ตามคำสั่งผู้ใช้", page)
+			break
+		}
+
 		// สร้าง URL สำหรับแต่ละหน้า
 		pageURL := fmt.Sprintf(baseURL, page)
 
-		// ทำ HTTP GET request
-		resp, err := http.Get(pageURL)
+		doc, err := fetchSearchPage(pageURL)
 		if err != nil {
-			log.Printf("ไม่สามารถเข้าถึงหน้าเว็บหน้า %d ได้: %v", page, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("การเข้าถึงหน้าเว็บล้มเหลวในหน้า %d: HTTP %d", page, resp.StatusCode)
+			log.Printf("ไม่สามารถดึงข้อมูลหน้า %d ได้: %v", page, err)
+			deadLetters.Add(pageURL, err, 1)
+			if err := tracker.Failed(page); err != nil {
+				log.Printf("หยุดดึงข้อมูล: %v", err)
+				break
+			}
 			continue
 		}
 
-		// สร้าง goquery document จากเนื้อหา HTML
-		doc, err := goquery.NewDocumentFromReader(resp.Body)
-		if err != nil {
-			log.Printf("ไม่สามารถแปลง HTML เป็น Document ในหน้า %d: %v", page, err)
-			continue
+		if tracker.Observe(doc) {
+			log.Printf("พบจำนวนหน้า: %s", tracker)
 		}
 
-		// Slice สำหรับเก็บลิงก์ที่ตรงตามเงื่อนไข
-		var dataURLs []string
-
-		// ดึงลิงก์ที่มี href="https://trustmarkthai.com/callbackData/popup.php?data="
-		doc.Find("a[href^='https://trustmarkthai.com/callbackData/popup.php?data=']").Each(func(i int, s *goquery.Selection) {
-			link, exists := s.Attr("href")
-			if exists {
-				dataURLs = append(dataURLs, link)
-			}
-		})
+		dataURLs := dataLinks(doc)
 
 		log.Printf("หน้า %d พบลิงก์ทั้งหมด %d รายการ", page, len(dataURLs))
 
+		if err := tracker.Check(page, dataURLs); err != nil {
+			log.Printf("หยุดที่หน้า %d: %v", page, err)
+			break
+		}
+
 		// วนลูปดึงข้อมูลจากแต่ละลิงก์
 		for _, url := range dataURLs {
+			if shutdownSignal.Stopping() {
+				break
+			}
 			info, err := fetchData(url, no)
 			if err != nil {
 				log.Printf("เกิดข้อผิดพลาดในการดึงข้อมูลจาก %s: %v", url, err)
+				// รายการที่ไม่มีอยู่แล้ว ดึงใหม่ก็ไม่ได้ผล
+				if !errors.Is(err, fetcherr.ErrNotFound) {
+					deadLetters.Add(url, err, 1)
+				}
 				continue
 			}
 			allData = append(allData, info)
+			upsertBusiness(url, info)
+			writeParquet(info)
 			no++
 		}
+		if !shutdownSignal.Stopping() {
+			donePage = page
+		}
 	}
 
 	// บันทึกผลลัพธ์ทั้งหมดเป็น JSON
-	jsonData, err := json.MarshalIndent(allData, "", "  ")
-	if err != nil {
-		log.Fatalf("ไม่สามารถแปลงข้อมูลทั้งหมดเป็น JSON: %v", err)
-	}
-
-	err = os.WriteFile("output.json", jsonData, 0644)
-	if err != nil {
+	if err := saveOutput("output.json", allData); err != nil {
 		log.Fatalf("ไม่สามารถบันทึก JSON ลงไฟล์: %v", err)
 	}
 
 	log.Println("บันทึกข้อมูลทั้งหมดลงในไฟล์ output.json สำเร็จ")
+
+	// รอบที่หยุดกลางทางหรือมีหน้าที่ดึงไม่สำเร็จได้ข้อมูลไม่ครบ
+	// รายการที่ยังไม่ได้ดึงจึงไม่นับว่าถูกลบ
+	partial := shutdownSignal.Stopping() || deadLetters.Failed() > 0
+	if summary, err := changes.Finish(previous, "output.json", diffSpec, partial); err != nil {
+		log.Printf("ไม่สามารถเปรียบเทียบกับผลลัพธ์รอบก่อน: %v", err)
+	} else {
+		summary.LogReport(log.Printf)
+	}
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "crawl", Page: donePage, Records: len(allData), Output: "output.json"}); err != nil {
+		log.Printf("ไม่สามารถบันทึก checkpoint: %v", err)
+	}
+}
+
+// dataLinks returns the popup.php record links on a search page
+func dataLinks(doc *goquery.Document) []string {
+	// Slice สำหรับเก็บลิงก์ที่ตรงตามเงื่อนไข
+	var dataURLs []string
+
+	// ดึงลิงก์ที่มี href="https://trustmarkthai.com/callbackData/popup.php?data="
+	doc.Find("a[href^='https://trustmarkthai.com/callbackData/popup.php?data=']").Each(func(i int, s *goquery.Selection) {
+		link, exists := s.Attr("href")
+		if exists {
+			dataURLs = append(dataURLs, link)
+		}
+	})
+	return dataURLs
+}
+
+// saveOutput writes the records as an indented JSON array
+func saveOutput(path string, all []BusinessInfo) error {
+	jsonData, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ไม่สามารถแปลงข้อมูลทั้งหมดเป็น JSON: %v", err)
+	}
+	return os.WriteFile(path, jsonData, 0644)
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+)
+
+// EntityType classifies the holder of a Thai national / juristic person ID
+type EntityType string
+
+const (
+	EntityUnknown        EntityType = "unknown"
+	EntityNaturalPerson  EntityType = "natural_person"
+	EntityJuristicPerson EntityType = "juristic_person"
+)
+
+// Checksum states for a parsed ID
+const (
+	ChecksumValid   = "valid"
+	ChecksumInvalid = "invalid"
+	ChecksumMasked  = "masked" // ไม่สามารถตรวจสอบได้เพราะเลขถูกปิดบางส่วน
+)
+
+// maskRunes are the characters trustmarkthai.com uses to hide digits
+const maskRunes = "*xX"
+
+// NationalID is a parsed 13-digit Thai national ID or juristic person ID.
+//
+// Juristic IDs (leading 0) are laid out as:
+//
+//	0 | office (2) | juristic type (1) | year B.E. (2) | sequence (6) | check (1)
+type NationalID struct {
+	Raw          string     // ค่าที่อ่านได้จากหน้าเว็บ
+	Normalized   string     // ตัวเลข 13 หลัก โดยตำแหน่งที่ถูกปิดเป็น '*'
+	EntityType   EntityType // บุคคลธรรมดา / นิติบุคคล
+	Masked       bool
+	MaskPattern  string // เช่น "#######******"
+	Checksum     string // valid, invalid หรือ masked
+	OfficeCode   string // รหัสสำนักงานที่รับจดทะเบียน (นิติบุคคลเท่านั้น)
+	JuristicType string // ประเภทนิติบุคคล (นิติบุคคลเท่านั้น)
+}
+
+// parseNationalID normalizes a raw ID and classifies it. IDs that are not 13
+// characters long after removing separators come back as EntityUnknown.
+func parseNationalID(raw string) NationalID {
+	id := NationalID{Raw: raw, EntityType: EntityUnknown}
+
+	var b strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case strings.ContainsRune(maskRunes, r):
+			b.WriteRune('*')
+		}
+	}
+	normalized := b.String()
+	if len(normalized) != 13 {
+		return id
+	}
+	id.Normalized = normalized
+
+	var pattern strings.Builder
+	for _, r := range normalized {
+		if r == '*' {
+			id.Masked = true
+			pattern.WriteRune('*')
+		} else {
+			pattern.WriteRune('#')
+		}
+	}
+	if id.Masked {
+		id.MaskPattern = pattern.String()
+	}
+
+	switch normalized[0] {
+	case '*':
+		// ตัวแรกถูกปิด แยกประเภทไม่ได้
+	case '0':
+		id.EntityType = EntityJuristicPerson
+		id.OfficeCode = visiblePart(normalized[1:3])
+		id.JuristicType = visiblePart(normalized[3:4])
+	default:
+		id.EntityType = EntityNaturalPerson
+	}
+
+	switch {
+	case id.Masked:
+		id.Checksum = ChecksumMasked
+	case validChecksum(normalized):
+		id.Checksum = ChecksumValid
+	default:
+		id.Checksum = ChecksumInvalid
+	}
+
+	return id
+}
+
+// JuristicID returns the ID to join against DBD company data, or "" when the
+// holder is not a juristic person or the unmasked ID fails the checksum.
+// Masked IDs are returned with their mask so they can still be prefix-matched.
+func (id NationalID) JuristicID() string {
+	if id.EntityType != EntityJuristicPerson || id.Checksum == ChecksumInvalid {
+		return ""
+	}
+	return id.Normalized
+}
+
+// validChecksum verifies the mod-11 check digit of an unmasked 13-digit ID
+func validChecksum(digits string) bool {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		sum += int(digits[i]-'0') * (13 - i)
+	}
+	return (11-sum%11)%10 == int(digits[12]-'0')
+}
+
+// visiblePart returns s unless any of it is masked
+func visiblePart(s string) string {
+	if strings.ContainsRune(s, '*') {
+		return ""
+	}
+	return s
+}
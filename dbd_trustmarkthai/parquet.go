@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"crawlkit/export"
+	"crawlkit/lake"
+)
+
+// businessRow is the Parquet schema of the businesses dataset
+type businessRow struct {
+	No              int64      `parquet:"no"`
+	NationalID      string     `parquet:"national_id"`
+	EntityType      string     `parquet:"entity_type,optional"`
+	JuristicID      string     `parquet:"juristic_id,optional"`
+	OwnerName       string     `parquet:"owner_name,optional"`
+	BusinessName    string     `parquet:"business_name,optional"`
+	OnlineStoreName string     `parquet:"online_store_name,optional"`
+	Platform        string     `parquet:"platform,optional"`
+	Stores          []storeRow `parquet:"stores,list"`
+	BusinessTypeTH  string     `parquet:"business_type_th,optional"`
+	BusinessTypeEN  string     `parquet:"business_type_en,optional"`
+	AddressTH       string     `parquet:"address_th,optional"`
+	AddressEN       string     `parquet:"address_en,optional"`
+	Province        string     `parquet:"province,optional"`
+	TrustmarkStatus string     `parquet:"trustmark_status,optional"`
+	LifecycleStatus string     `parquet:"lifecycle_status"`
+	FirstRegistered int32      `parquet:"first_registered,optional,date"`
+	Renewed         int32      `parquet:"renewed,optional,date"`
+	Expires         int32      `parquet:"expires,optional,date"`
+}
+
+type storeRow struct {
+	Platform string `parquet:"platform"`
+	URL      string `parquet:"url,optional"`
+	Handle   string `parquet:"handle,optional"`
+}
+
+func newBusinessRow(info BusinessInfo) businessRow {
+	row := businessRow{
+		No:              int64(info.No),
+		NationalID:      info.NationalID,
+		EntityType:      info.EntityType,
+		JuristicID:      info.JuristicID,
+		OwnerName:       info.OwnerName,
+		BusinessName:    info.BusinessName,
+		OnlineStoreName: info.OnlineStoreName,
+		Platform:        info.Platform,
+		BusinessTypeTH:  info.BusinessTypeTH,
+		BusinessTypeEN:  info.BusinessTypeEN,
+		AddressTH:       info.AddressTH,
+		AddressEN:       info.AddressEN,
+		Province:        lake.Province(info.AddressTH),
+		TrustmarkStatus: info.TrustmarkStatus,
+		LifecycleStatus: string(info.Lifecycle.Status),
+		FirstRegistered: lake.Day(info.Lifecycle.FirstRegistered.Time),
+		Renewed:         lake.Day(info.Lifecycle.Renewed.Time),
+		Expires:         lake.Day(info.Lifecycle.Expires.Time),
+	}
+	for _, s := range info.Stores {
+		row.Stores = append(row.Stores, storeRow{Platform: s.Platform, URL: s.URL, Handle: s.Handle})
+	}
+	return row
+}
+
+// businessLake รับทุกรายการในรูป Parquet เมื่อกำหนด --parquet-dir (nil = ไม่ใช้)
+var businessLake *lake.Dataset[businessRow]
+
+// crawlDate คือวันที่เริ่มรัน ใช้แบ่ง partition ของ Parquet
+var crawlDate time.Time
+
+// writeParquet เพิ่มรายการที่อ่านได้ลงใน dataset businesses
+func writeParquet(info BusinessInfo) {
+	row := newBusinessRow(info)
+	if err := businessLake.Write(crawlDate, row.Province, row); err != nil {
+		log.Printf("ไม่สามารถเขียน Parquet: %v", err)
+	}
+}
+
+// closeParquet ปิดไฟล์ Parquet ให้สมบูรณ์และรายงานจำนวนแถวที่เขียน
+func closeParquet() {
+	if err := businessLake.Close(); err != nil {
+		log.Printf("ไม่สามารถปิดไฟล์ Parquet: %v", err)
+	}
+	businessLake.LogReport(log.Printf)
+}
+
+// exportParquet เขียนผลการดึงข้อมูลเดิมเป็น Parquet ตามวันที่ดึงและจังหวัด
+func exportParquet(opts export.Options, all []BusinessInfo) {
+	date, err := lake.CrawlDate(opts.CrawlDate, opts.Input)
+	if err != nil {
+		log.Fatalf("วันที่ดึงข้อมูลไม่ถูกต้อง: %v", err)
+	}
+	businessLake = lake.Open[businessRow](lake.Options{Dir: opts.Dir()}, "businesses", lake.InputRun(opts.Input))
+	crawlDate = date
+	for _, info := range all {
+		writeParquet(info)
+	}
+	closeParquet()
+}
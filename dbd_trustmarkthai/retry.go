@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/shutdown"
+)
+
+// runRetryFailed replays the dead-letter file through the same parser,
+// merges the recovered records into the crawl output and keeps only the URLs
+// that still fail.
+//
+//	go run . retry-failed [--dead-letter failed.jsonl] [--output output.json]
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	output := fs.String("output", "output.json", "crawl output to merge recovered records into")
+	var fetch fetchOptions
+	fetch.addFlags(fs)
+	fs.Parse(args)
+
+	entries, err := deadletter.Load(fetch.deadLetter.File)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		log.Printf("ไม่มี URL ที่ต้องดึงใหม่ใน %s", fetch.deadLetter.File)
+		return
+	}
+	if err != nil {
+		log.Fatalf("ไม่สามารถอ่านไฟล์ %s: %v", fetch.deadLetter.File, err)
+	}
+
+	all, err := loadOutput(*output)
+	if err != nil {
+		log.Fatalf("ไม่สามารถอ่านไฟล์ %s: %v", *output, err)
+	}
+
+	proxyPool := fetch.setup("retry-failed")
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer closeParquet()
+	defer proxyPool.LogReport(log.Printf)
+	defer sqliteDB.LogReport(log.Printf)
+	// ไฟล์ dead-letter ถูกเขียนใหม่ทั้งไฟล์ตอนจบ จึงไม่ต่อท้ายระหว่างรัน
+	deadLetters = nil
+
+	var recovered []BusinessInfo
+	var found []deadletter.Entry
+	n, failed := deadletter.Retry(entries, func(rawURL string) error {
+		if !isSearchPage(rawURL) {
+			info, err := fetchData(rawURL, 0)
+			if err != nil {
+				return err
+			}
+			recovered = append(recovered, info)
+			upsertBusiness(rawURL, info)
+			writeParquet(info)
+			return nil
+		}
+
+		// หน้าค้นหาที่เคยล้มเหลว: ดึงทุกรายการในหน้านั้น
+		doc, err := fetchSearchPage(rawURL)
+		if err != nil {
+			return err
+		}
+		for _, link := range dataLinks(doc) {
+			if shutdownSignal.Stopping() {
+				// รายการที่ยังไม่ได้ดึงจะได้จากหน้าค้นหานี้อีกครั้งในรอบหน้า
+				return fetcherr.Fetch(fetcherr.StageFetch, rawURL, context.Canceled)
+			}
+			info, err := fetchData(link, 0)
+			if err != nil {
+				log.Printf("เกิดข้อผิดพลาดในการดึงข้อมูลจาก %s: %v", link, err)
+				if !errors.Is(err, fetcherr.ErrNotFound) {
+					found = append(found, deadletter.NewEntry(link, err, 1))
+				}
+				continue
+			}
+			recovered = append(recovered, info)
+			upsertBusiness(link, info)
+			writeParquet(info)
+		}
+		return nil
+	}, shutdownSignal.Stopping, log.Printf)
+
+	all, added := mergeRecords(all, recovered)
+	if err := saveOutput(*output, all); err != nil {
+		log.Fatalf("ไม่สามารถบันทึก JSON ลงไฟล์: %v", err)
+	}
+	if err := deadletter.Save(fetch.deadLetter.File, append(failed, found...)); err != nil {
+		log.Fatalf("ไม่สามารถบันทึกไฟล์ %s: %v", fetch.deadLetter.File, err)
+	}
+	log.Printf("ดึงใหม่สำเร็จ %d จาก %d URL (เพิ่ม %d รายการ, แทนที่ %d รายการใน %s), ยังล้มเหลว %d URL",
+		n, len(entries), added, len(recovered)-added, *output, len(failed)+len(found))
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "retry-failed", Records: len(all), Output: *output}); err != nil {
+		log.Printf("ไม่สามารถบันทึก checkpoint: %v", err)
+	}
+}
+
+func isSearchPage(rawURL string) bool {
+	return strings.Contains(rawURL, "trustmarkthai.com/th/search")
+}
+
+// loadOutput reads an earlier crawl; a missing file is an empty crawl
+func loadOutput(path string) ([]BusinessInfo, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var all []BusinessInfo
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// mergeRecords replaces records that are already in the output and appends
+// the rest with the next free No. It returns the merged list and how many
+// were appended.
+func mergeRecords(all, recovered []BusinessInfo) ([]BusinessInfo, int) {
+	index := map[string]int{}
+	next := 1
+	for i, info := range all {
+		index[recordKey(info)] = i
+		if info.No >= next {
+			next = info.No + 1
+		}
+	}
+
+	added := 0
+	for _, info := range recovered {
+		if i, ok := index[recordKey(info)]; ok {
+			info.No = all[i].No
+			all[i] = info
+			continue
+		}
+		info.No = next
+		next++
+		index[recordKey(info)] = len(all)
+		all = append(all, info)
+		added++
+	}
+	return all, added
+}
+
+// recordKey identifies a business across crawls: one owner can run several
+// online stores, each with its own trustmark
+func recordKey(info BusinessInfo) string {
+	return info.NationalID + "|" + info.OnlineStoreName
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+
+	"crawlkit/schema"
+)
+
+// recordSchema คือสัญญาของ output.json ที่สร้างจาก BusinessInfo
+var recordSchema = schema.Generate[BusinessInfo]("BusinessInfo", "A DBD Registered trustmark record from trustmarkthai.com", map[string]schema.Field{
+	"platform":          {Enum: platforms(), Empty: true, Description: "platform of the first store, or else of the platform the store field mentions"},
+	"stores[].platform": {Enum: platforms()},
+	"stores[].url":      {Format: "uri"},
+	"lifecycle.status": {Enum: []string{
+		string(StatusActive), string(StatusExpired), string(StatusSuspended), string(StatusRevoked), string(StatusUnknown),
+	}},
+	"lifecycle.first_registered": schema.Date,
+	"lifecycle.renewed":          schema.Date,
+	"lifecycle.expires":          schema.Date,
+})
+
+// platforms คือชื่อแพลตฟอร์มทั้งหมดที่ parseStores ให้ได้
+func platforms() []string {
+	seen := map[string]bool{platformWebsite: true, "LINE": true}
+	for _, platform := range platformDomains {
+		seen[platform] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runSchema เขียน JSON Schema ของ output.json
+//
+//	go run . schema [--output business_info.schema.json]
+func runSchema(args []string) {
+	message, err := schema.RunSchema(args, recordSchema, "business_info")
+	if err != nil {
+		log.Fatalf("ไม่สามารถเขียน JSON Schema: %v", err)
+	}
+	if message != "" {
+		log.Println(message)
+	}
+}
+
+// runValidate ตรวจไฟล์ผลลัพธ์กับ JSON Schema และรายงานจุดที่ผิดเป็น JSON pointer
+//
+//	go run . validate [--input output.json] [--schema business_info.schema.json] [--max 20]
+func runValidate(args []string) {
+	report, err := schema.RunValidate(args, recordSchema, "output.json")
+	if err != nil {
+		log.Fatalf("ไม่สามารถตรวจไฟล์: %v", err)
+	}
+	report.LogReport(log.Printf)
+	if report.Invalid > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"net/url"
+
+	"crawlkit/sink"
+)
+
+// upsertBusiness เขียนรายการที่อ่านได้ลง --sqlite ด้วยคีย์ national_id|online_store_name
+// และเก็บ token data ของ popup ไว้อีกคอลัมน์
+func upsertBusiness(link string, info BusinessInfo) {
+	b := sink.Business{
+		DataToken:       dataToken(link),
+		NationalID:      info.NationalID,
+		EntityType:      info.EntityType,
+		OwnerName:       info.OwnerName,
+		BusinessName:    info.BusinessName,
+		OnlineStoreName: info.OnlineStoreName,
+		Platform:        info.Platform,
+		BusinessType:    info.BusinessTypeTH,
+		TrustmarkStatus: info.TrustmarkStatus,
+		Record:          info,
+	}
+	if !info.Lifecycle.Expires.IsZero() {
+		b.ExpiresOn = info.Lifecycle.Expires.Format(dateLayout)
+	}
+	if err := sqliteDB.UpsertBusiness(b); err != nil {
+		log.Printf("ไม่สามารถบันทึก %s ลง SQLite: %v", link, err)
+	}
+}
+
+// dataToken คืนค่าพารามิเตอร์ data ของลิงก์ popup.php ซึ่งไม่ซ้ำกันในแต่ละรายการ
+func dataToken(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("data")
+}
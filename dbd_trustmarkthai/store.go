@@ -0,0 +1,232 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Store is one online storefront listed in a trustmark record
+type Store struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url,omitempty"`
+	Handle   string `json:"handle,omitempty"`
+}
+
+// platformWebsite is used for any domain missing from platformDomains
+const platformWebsite = "Website"
+
+// platformDomains maps a registrable domain to its platform name. Subdomains
+// (s.lazada.co.th, vt.tiktok.com, shop.line.me, ...) match their parent entry.
+var platformDomains = map[string]string{
+	"facebook.com":  "Facebook",
+	"fb.com":        "Facebook",
+	"fb.me":         "Facebook",
+	"shopee.co.th":  "Shopee",
+	"lazada.co.th":  "Lazada",
+	"tiktok.com":    "TikTok",
+	"instagram.com": "Instagram",
+	"line.me":       "LINE",
+	"lin.ee":        "LINE",
+	"x.com":         "Twitter",
+	"twitter.com":   "Twitter",
+}
+
+// handleLabels maps the label written before an @handle, such as "IG: @shop",
+// to its platform
+var handleLabels = map[string]string{
+	"fb":        "Facebook",
+	"facebook":  "Facebook",
+	"ig":        "Instagram",
+	"instagram": "Instagram",
+	"line":      "LINE",
+	"tiktok":    "TikTok",
+	"tt":        "TikTok",
+	"twitter":   "Twitter",
+	"x":         "Twitter",
+	"shopee":    "Shopee",
+	"lazada":    "Lazada",
+}
+
+var (
+	// "Line ID: @abc", "LineID : abc", "Line id:Line/0812345678"
+	lineIDRegex = regexp.MustCompile(`(?i)\bline\s*id\s*[:：]?\s*(?:line/)?(@?[\w.\-]+)`)
+	// shop.th@gmail.com, removed first so neither half is taken for a store
+	emailRegex = regexp.MustCompile(`(?i)[\w.+\-]+@(?:[a-z0-9-]+\.)+[a-z]{2,}`)
+	// www.shop.com/path, https://x.com/abc, shopee.co.th/abc?x=1 and IDN
+	// domains such as ร้านค้า.com or xn--12c.xn--o3cw4h; a label is either
+	// ASCII or not, so Thai text run into a domain is not taken as part of it
+	storeURLRegex = regexp.MustCompile(`(?i)(?:https?://)?(?:(?:[a-z0-9-]+|[^\x00-\x7F\s.,/@:|]+)\.)+(?:[a-z]{2,}|xn--[a-z0-9-]+|ไทย)(?::\d+)?(?:/[^\s,]*)?`)
+	// "IG: @abc", "FB @abc"; an @abc with no label is taken for a LINE
+	// Official Account
+	handleRegex = regexp.MustCompile(`(?i)(?:^|[\s,/|])(?:([a-z]+)\s*[:：]?\s*)?(@[\w.\-]+)`)
+)
+
+// parseStores extracts every URL, LINE ID and social handle from the online
+// store field of a trustmark record.
+func parseStores(value string) []Store {
+	var stores []Store
+	rest := value
+
+	for _, m := range lineIDRegex.FindAllStringSubmatch(rest, -1) {
+		stores = append(stores, Store{Platform: "LINE", Handle: m[1]})
+	}
+	rest = lineIDRegex.ReplaceAllString(rest, " ")
+	rest = emailRegex.ReplaceAllString(rest, " ")
+
+	// only the URLs taken are cut out, so the handles are left for below
+	var kept strings.Builder
+	last := 0
+	for _, loc := range storeURLRegex.FindAllStringIndex(rest, -1) {
+		// ข้าม @handle ที่มีจุด เช่น @shop.official
+		if loc[0] > 0 && rest[loc[0]-1] == '@' {
+			continue
+		}
+		if store, ok := parseStoreURL(rest[loc[0]:loc[1]]); ok {
+			stores = append(stores, store)
+			kept.WriteString(rest[last:loc[0]])
+			kept.WriteString(" ")
+			last = loc[1]
+		}
+	}
+	kept.WriteString(rest[last:])
+	rest = kept.String()
+
+	for _, m := range handleRegex.FindAllStringSubmatch(rest, -1) {
+		platform, ok := handleLabels[strings.ToLower(m[1])]
+		if !ok {
+			platform = "LINE"
+		}
+		stores = append(stores, Store{Platform: platform, Handle: m[2]})
+	}
+
+	return stores
+}
+
+// textPlatform guesses the platform of a store field that names no URL or
+// handle, such as "Facebook: ร้านของฉัน", by the platform it mentions
+func textPlatform(value string) string {
+	value = strings.ToLower(value)
+	for _, p := range []struct{ keyword, platform string }{
+		{"facebook", "Facebook"},
+		{"shopee", "Shopee"},
+		{"lazada", "Lazada"},
+		{"line", "LINE"},
+		{"instagram", "Instagram"},
+		{"twitter", "Twitter"},
+		{"tiktok", "TikTok"},
+	} {
+		if strings.Contains(value, p.keyword) {
+			return p.platform
+		}
+	}
+	return platformWebsite
+}
+
+// parseStoreURL normalizes a raw URL or bare domain and works out its
+// platform and account handle.
+func parseStoreURL(raw string) (Store, bool) {
+	raw = strings.TrimRight(raw, ".;)")
+	if !strings.Contains(strings.ToLower(raw), "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return Store{}, false
+	}
+	// IDN domains are written in punycode, as url.URL would escape them
+	host, err := idna.Lookup.ToASCII(u.Host)
+	if err != nil {
+		return Store{}, false
+	}
+	u.Host = host
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	store := Store{Platform: lookupPlatform(u.Hostname()), URL: u.String()}
+	store.Handle = handleFromURL(store.Platform, u)
+	return store, true
+}
+
+// lookupPlatform walks up the domain labels until one is in platformDomains
+func lookupPlatform(host string) string {
+	host = strings.TrimPrefix(host, "www.")
+	for {
+		if platform, ok := platformDomains[host]; ok {
+			return platform
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			return platformWebsite
+		}
+		host = host[i+1:]
+	}
+}
+
+// handleFromURL returns the account name encoded in a platform URL, or "" for
+// short links, share links and plain websites.
+func handleFromURL(platform string, u *url.URL) string {
+	var segments []string
+	for _, s := range strings.Split(u.Path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	first := ""
+	if len(segments) > 0 {
+		first = segments[0]
+	}
+
+	switch platform {
+	case "Facebook":
+		switch strings.ToLower(first) {
+		case "profile.php":
+			return u.Query().Get("id")
+		case "", "share", "groups", "watch", "story.php", "permalink.php":
+			return ""
+		}
+		return first
+	case "Instagram", "Twitter":
+		switch strings.ToLower(first) {
+		case "", "p", "reel", "share", "i":
+			return ""
+		}
+		return first
+	case "TikTok":
+		if strings.HasPrefix(first, "@") {
+			return strings.TrimPrefix(first, "@")
+		}
+		return ""
+	case "Shopee":
+		if strings.HasPrefix(u.Hostname(), "s.") || first == "shop" || first == "product" {
+			return ""
+		}
+		return first
+	case "Lazada":
+		if strings.HasPrefix(u.Hostname(), "s.") {
+			return ""
+		}
+		if first == "shop" && len(segments) > 1 {
+			return segments[1]
+		}
+		if first == "shop" || first == "products" {
+			return ""
+		}
+		return first
+	case "LINE":
+		// line.me/R/ti/p/%40abc, line.me/ti/p/~abc, shop.line.me/@abc
+		if len(segments) == 0 || u.Hostname() == "lin.ee" {
+			return ""
+		}
+		last := segments[len(segments)-1]
+		if unescaped, err := url.PathUnescape(last); err == nil {
+			last = unescaped
+		}
+		if first == "ti" || first == "R" || strings.HasPrefix(last, "@") || strings.HasPrefix(last, "~") {
+			return strings.TrimPrefix(last, "~")
+		}
+		return ""
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"crawlkit/block"
+	"crawlkit/fetcherr"
+)
+
+// PageStatus is the verdict on a fetched product_detail.php page
+type PageStatus string
+
+const (
+	PageFound    PageStatus = "found"
+	PageNotFound PageStatus = "not_found"
+	PageError    PageStatus = "error_page"
+	PageBlocked  PageStatus = "blocked"
+)
+
+// ProductMetadata records where a product came from and why it was kept
+type ProductMetadata struct {
+	PageStatus   PageStatus `json:"page_status"`
+	Reason       string     `json:"reason,omitempty"`
+	HTTPStatus   int        `json:"http_status"`
+	Tables       int        `json:"tables"`
+	KeyFields    int        `json:"key_fields"`
+	TemplateHash string     `json:"template_hash,omitempty"`
+	SourceURL    string     `json:"source_url"`
+	FetchedAt    time.Time  `json:"fetched_at"`
+}
+
+// Markers of a PHP / database error page. WAF, captcha and rate-limit pages
+// are recognised by block.Detect.
+var errorMarkers = []string{
+	"fatal error", "parse error", "warning</b>:", "mysql_", "mysqli_",
+	"internal server error", "service unavailable",
+}
+
+// knownEmptyTemplates are fingerprints of the product section the site
+// renders for a ps_id that does not exist: the enterprise table is filled in
+// but every product field is blank or a form placeholder.
+var knownEmptyTemplates = map[string]string{
+	productFingerprint(Product{}): "blank product table",
+	productFingerprint(Product{
+		ProductionCapacity: "(ปริมาณ:หน่วยนับ/เดือน)",
+		ProductionPeriod:   "ระหว่างเดือน ถึงเดือน",
+		SeasonalUse:        "-",
+	}): "empty product template",
+}
+
+// classifyResponse decides from the raw response alone whether a page can
+// hold a product at all. It returns "" when the page should be parsed.
+func classifyResponse(httpStatus int, body string, tables int) (PageStatus, string) {
+	if blocked, reason := block.Detect(httpStatus, []byte(body)); blocked {
+		return PageBlocked, reason
+	}
+	lower := strings.ToLower(body)
+	for _, marker := range errorMarkers {
+		if strings.Contains(lower, marker) {
+			return PageError, fmt.Sprintf("error marker %q", marker)
+		}
+	}
+	switch {
+	case httpStatus == http.StatusNotFound:
+		return PageNotFound, "HTTP 404"
+	case httpStatus != http.StatusOK:
+		return PageError, fmt.Sprintf("HTTP %d", httpStatus)
+	case tables < 2:
+		return PageNotFound, fmt.Sprintf("%d detail tables, expected 2", tables)
+	}
+	return "", ""
+}
+
+// failure returns the verdict on a page that is not a product as a
+// fetcherr error, or nil for a product
+func (m ProductMetadata) failure() error {
+	switch m.PageStatus {
+	case PageFound:
+		return nil
+	case PageNotFound:
+		return fetcherr.NotFound(m.SourceURL, m.Reason)
+	case PageBlocked:
+		return fetcherr.New(fetcherr.ErrBlocked, fetcherr.StageRead, m.SourceURL, errors.New(m.Reason))
+	}
+	if m.HTTPStatus != http.StatusOK {
+		return &fetcherr.Error{Kind: fetcherr.ErrStatus, Stage: fetcherr.StageFetch, URL: m.SourceURL, Status: m.HTTPStatus}
+	}
+	return fetcherr.Structure(m.SourceURL, "error page, %s", m.Reason)
+}
+
+// classifyProduct looks at the parsed product fields and records the verdict
+// in its metadata
+func classifyProduct(product *Product) {
+	meta := &product.Metadata
+	meta.TemplateHash = productFingerprint(*product)
+
+	for _, field := range []string{
+		product.ProductName, product.Properties, product.Composition,
+		product.NutritionInfo, product.PricePerTon, product.Standards,
+	} {
+		if field != "" {
+			meta.KeyFields++
+		}
+	}
+
+	if template, ok := knownEmptyTemplates[meta.TemplateHash]; ok {
+		meta.PageStatus = PageNotFound
+		meta.Reason = template
+		return
+	}
+	if meta.KeyFields == 0 {
+		meta.PageStatus = PageNotFound
+		meta.Reason = "no product fields"
+		return
+	}
+	meta.PageStatus = PageFound
+}
+
+// productFingerprint hashes the product-level fields, leaving out the
+// enterprise-level ones (quality assurance, distribution channels) that the
+// site fills in even when the product does not exist
+func productFingerprint(p Product) string {
+	h := sha256.New()
+	for _, field := range []string{
+		p.ProductName, p.Properties, p.Composition, p.NutritionInfo,
+		p.ProductionCapacity, p.PricePerTon, p.Standards,
+		p.ProductionPeriod, p.SeasonalUse,
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
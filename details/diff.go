@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"crawlkit/diff"
+)
+
+// diffSpec matches the products of two runs by their SMCE IDs; the fetch
+// time changes on every run
+var diffSpec = diff.Spec{
+	Noun:   "products",
+	Key:    []string{"smce_id", "ps_id"},
+	Ignore: []string{"metadata.fetched_at"},
+}
+
+// runDiff compares two snapshots and writes the added, removed and changed
+// records as NDJSON
+//
+//	go run . diff --before last-week.json [--after output.json] [--output diff.ndjson]
+func runDiff(args []string) {
+	summary, err := diff.RunCommand(args, diffSpec, "output.json")
+	if err != nil {
+		fmt.Println("Error comparing snapshots:", err)
+		return
+	}
+	summary.LogReport(logf)
+}
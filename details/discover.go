@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"crawlkit/fetcherr"
+	"crawlkit/paging"
+	"crawlkit/smce"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// productRef identifies one product_detail.php page
+type productRef struct {
+	SMCEID string
+	PSID   string
+}
+
+// discoverProductRefs collects the smce_id/ps_id pairs that the site actually
+// links to: first from the ProductC_Result.php listing, then (optionally)
+// from the managecontent.php page of every enterprise in SmceCategory.php and
+// every enterprise seen in the product listing. The same search filter is
+// applied to both listings.
+func discoverProductRefs(filter smce.Filter, withEnterprises bool) []productRef {
+	var refs []productRef
+	seenRefs := make(map[productRef]bool)
+
+	var smceIDs []string
+	seenSMCE := make(map[string]bool)
+	addSMCE := func(smceID string) {
+		if smceID != "" && !seenSMCE[smceID] {
+			seenSMCE[smceID] = true
+			smceIDs = append(smceIDs, smceID)
+		}
+	}
+	addRefs := func(doc *goquery.Document, pageURL string) {
+		for _, ref := range productLinks(doc, pageURL) {
+			addSMCE(ref.SMCEID)
+			if !seenRefs[ref] {
+				seenRefs[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	// Product listing: every row links straight to product_detail.php
+	walkListing(func(page int) string { return filter.ProductPageURL(5, page) }, 5, addRefs)
+	fmt.Printf("Product listing: %d products from %d enterprises\n", len(refs), len(smceIDs))
+
+	if !withEnterprises {
+		return refs
+	}
+
+	// Enterprise listing: rows link to managecontent.php?smce_id=...
+	walkListing(func(page int) string { return filter.CategoryPageURL(10, page) }, 10, func(doc *goquery.Document, pageURL string) {
+		doc.Find("a[href*='managecontent.php']").Each(func(i int, a *goquery.Selection) {
+			href, _ := a.Attr("href")
+			if u, err := resolveLink(pageURL, href); err == nil {
+				addSMCE(u.Query().Get("smce_id"))
+			}
+		})
+	})
+	fmt.Printf("Enterprise listing: %d enterprises in total\n", len(smceIDs))
+
+	// Each enterprise page lists all of its products
+	for i, smceID := range smceIDs {
+		if shutdownSignal.Stopping() {
+			break
+		}
+		pageURL := smce.BaseURL + "ProductCategory/managecontent.php?smce_id=" + url.QueryEscape(smceID)
+		doc, err := fetchDocument(pageURL)
+		if err != nil {
+			fmt.Printf("Enterprise smce_id=%s: %v\n", smceID, err)
+			deadLetters.Add(pageURL, err, 1)
+			continue
+		}
+		before := len(refs)
+		addRefs(doc, pageURL)
+		fmt.Printf("Enterprise %d/%d smce_id=%s: %d new products\n", i+1, len(smceIDs), smceID, len(refs)-before)
+		shutdownSignal.Sleep(200 * time.Millisecond)
+	}
+
+	return refs
+}
+
+// probeProductRefs enumerates a bounded smce_id x ps_id range for finding
+// products the listings do not link to. Pairs already present in the known
+// output file are skipped.
+func probeProductRefs(smceFrom, smceTo int64, psFrom, psTo, maxProbes int, knownFile string) ([]productRef, error) {
+	if smceTo < smceFrom || psTo < psFrom {
+		return nil, fmt.Errorf("empty probe range")
+	}
+	total := (smceTo - smceFrom + 1) * int64(psTo-psFrom+1)
+	if total > int64(maxProbes) {
+		return nil, fmt.Errorf("probe range has %d IDs, more than -max-probes=%d", total, maxProbes)
+	}
+
+	known := make(map[productRef]bool)
+	if knownFile != "" {
+		products, err := loadProducts(knownFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading known products: %v", err)
+		}
+		for _, p := range products {
+			known[productRef{SMCEID: p.SMCEID, PSID: p.PSID}] = true
+		}
+	}
+
+	var refs []productRef
+	for smceID := smceFrom; smceID <= smceTo; smceID++ {
+		for psID := psFrom; psID <= psTo; psID++ {
+			ref := productRef{SMCEID: strconv.FormatInt(smceID, 10), PSID: strconv.Itoa(psID)}
+			if !known[ref] {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// walkListing fetches every page of a paginated SMCE listing and hands each
+// one to visit. The last page comes from the result count, and the walk also
+// stops on an empty page, a page that repeats an earlier one or too many
+// failed pages.
+func walkListing(urlFor func(page int) string, pageSize int, visit func(doc *goquery.Document, pageURL string)) {
+	tracker := paging.NewTracker("PAGE", pageSize)
+
+	for page := 1; tracker.More(page); page++ {
+		if shutdownSignal.Stopping() {
+			break
+		}
+		doc, err := fetchDocument(urlFor(page))
+		if err != nil {
+			fmt.Printf("Listing page %d: %v\n", page, err)
+			deadLetters.Add(urlFor(page), err, 1)
+			if err := tracker.Failed(page); err != nil {
+				fmt.Printf("Listing stopped: %v\n", err)
+				break
+			}
+			continue
+		}
+
+		tracker.Observe(doc)
+
+		var keys []string
+		doc.Find("table.table tbody tr").Each(func(i int, row *goquery.Selection) {
+			keys = append(keys, cleanText(row.Text()))
+		})
+		if err := tracker.Check(page, keys); err != nil {
+			fmt.Printf("Listing stopped at page %d: %v\n", page, err)
+			break
+		}
+
+		visit(doc, urlFor(page))
+		shutdownSignal.Sleep(200 * time.Millisecond)
+	}
+}
+
+// productLinks returns every product_detail.php link on a page
+func productLinks(doc *goquery.Document, pageURL string) []productRef {
+	var refs []productRef
+	doc.Find("a[href*='product_detail.php']").Each(func(i int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		u, err := resolveLink(pageURL, href)
+		if err != nil {
+			return
+		}
+		ref := productRef{SMCEID: u.Query().Get("smce_id"), PSID: u.Query().Get("ps_id")}
+		if ref.SMCEID != "" && ref.PSID != "" {
+			refs = append(refs, ref)
+		}
+	})
+	return refs
+}
+
+// resolveLink resolves a possibly relative href against the page it was found on
+func resolveLink(pageURL, href string) (*url.URL, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	return base.Parse(strings.TrimSpace(href))
+}
+
+// fetchDocument downloads and parses any SMCE page
+func fetchDocument(pageURL string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request: %v", err)
+	}
+	setHeaders(req)
+
+	resp, err := robotsChecker.Do(httpClient, req)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageFetch, pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := blockDetector.ReadBody(pageURL, resp)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageRead, pageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetcherr.Status(pageURL, resp.StatusCode)
+	}
+
+	utf8Reader, err := charset.NewReader(bytes.NewReader(body), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fetcherr.Decode(pageURL, err)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(utf8Reader)
+	if err != nil {
+		return nil, fetcherr.Decode(pageURL, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"crawlkit/export"
+)
+
+// runExport writes an earlier crawl as CSV or XLSX for Excel, or as Parquet.
+// Representatives are joined with --separator in the CSV and get their own
+// sheet in the XLSX.
+//
+//	go run . export [--format csv|xlsx|parquet] [--input output.json] [--output export.csv] [--separator "; "]
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var opts export.Options
+	opts.AddFlags(fs, "output.json")
+	fs.Parse(args)
+
+	products, err := export.ReadRecords[Product](opts.Input)
+	if err != nil {
+		fmt.Println("Error reading output file:", err)
+		return
+	}
+	if opts.Format == "parquet" {
+		exportParquet(opts, products)
+		return
+	}
+	spec := export.Spec{
+		Name:    "products",
+		Key:     []string{"smce_id", "ps_id"},
+		Numbers: []string{"price_per_ton", "latitude", "longitude"},
+	}
+	path, err := export.Write(opts, spec, products)
+	if err != nil {
+		fmt.Println("Error exporting products:", err)
+		return
+	}
+	fmt.Printf("Exported %d products to %s\n", len(products), path)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"crawlkit/export"
+	"crawlkit/lake"
+)
+
+// productRow is the Parquet schema of the products dataset
+type productRow struct {
+	SMCEID               string              `parquet:"smce_id"`
+	PSID                 string              `parquet:"ps_id"`
+	OrganizationName     string              `parquet:"organization_name,optional"`
+	RegistrationCode     string              `parquet:"registration_code,optional"`
+	Address              string              `parquet:"address,optional"`
+	Province             string              `parquet:"province,optional"`
+	Phone                string              `parquet:"phone,optional"`
+	Fax                  string              `parquet:"fax,optional"`
+	Representatives      []representativeRow `parquet:"representatives,list"`
+	ProductName          string              `parquet:"product_name,optional"`
+	Properties           string              `parquet:"properties,optional"`
+	Composition          string              `parquet:"composition,optional"`
+	NutritionInfo        string              `parquet:"nutrition_info,optional"`
+	ProductionCapacity   string              `parquet:"production_capacity,optional"`
+	PricePerTon          string              `parquet:"price_per_ton,optional"`
+	Standards            string              `parquet:"standards,optional"`
+	QualityAssurance     string              `parquet:"quality_assurance,optional"`
+	ProductionPeriod     string              `parquet:"production_period,optional"`
+	SeasonalUse          string              `parquet:"seasonal_use,optional"`
+	DistributionChannels string              `parquet:"distribution_channels,optional"`
+	Latitude             float64             `parquet:"latitude,optional"`
+	Longitude            float64             `parquet:"longitude,optional"`
+	PageStatus           string              `parquet:"page_status"`
+	SourceURL            string              `parquet:"source_url,optional"`
+	FetchedAt            int64               `parquet:"fetched_at,optional,timestamp(millisecond)"`
+}
+
+type representativeRow struct {
+	Name string `parquet:"name"`
+}
+
+func newProductRow(p Product) productRow {
+	row := productRow{
+		SMCEID:               p.SMCEID,
+		PSID:                 p.PSID,
+		OrganizationName:     p.OrganizationName,
+		RegistrationCode:     p.RegistrationCode,
+		Address:              p.Address,
+		Province:             lake.Province(p.Address),
+		Phone:                p.Phone,
+		Fax:                  p.Fax,
+		ProductName:          p.ProductName,
+		Properties:           p.Properties,
+		Composition:          p.Composition,
+		NutritionInfo:        p.NutritionInfo,
+		ProductionCapacity:   p.ProductionCapacity,
+		PricePerTon:          p.PricePerTon,
+		Standards:            p.Standards,
+		QualityAssurance:     p.QualityAssurance,
+		ProductionPeriod:     p.ProductionPeriod,
+		SeasonalUse:          p.SeasonalUse,
+		DistributionChannels: p.DistributionChannels,
+		Latitude:             lake.Float(p.Latitude),
+		Longitude:            lake.Float(p.Longitude),
+		PageStatus:           string(p.Metadata.PageStatus),
+		SourceURL:            p.Metadata.SourceURL,
+		FetchedAt:            lake.Timestamp(p.Metadata.FetchedAt),
+	}
+	for _, name := range p.Representatives {
+		row.Representatives = append(row.Representatives, representativeRow{Name: name})
+	}
+	return row
+}
+
+// productLake receives every saved product when --parquet-dir is set
+var productLake *lake.Dataset[productRow]
+
+// crawlDate partitions the Parquet output; it is when the run started
+var crawlDate time.Time
+
+// writeParquet adds a saved product to the products dataset
+func writeParquet(p Product) {
+	row := newProductRow(p)
+	if err := productLake.Write(crawlDate, row.Province, row); err != nil {
+		fmt.Println("Error writing Parquet:", err)
+	}
+}
+
+// closeParquet finishes the Parquet files and reports how many rows they hold
+func closeParquet() {
+	if err := productLake.Close(); err != nil {
+		fmt.Println("Error closing Parquet files:", err)
+	}
+	productLake.LogReport(logf)
+}
+
+// exportParquet writes an earlier crawl as Parquet, by crawl date and province
+func exportParquet(opts export.Options, products []Product) {
+	date, err := lake.CrawlDate(opts.CrawlDate, opts.Input)
+	if err != nil {
+		fmt.Println("Invalid crawl date:", err)
+		return
+	}
+	productLake = lake.Open[productRow](lake.Options{Dir: opts.Dir()}, "products", lake.InputRun(opts.Input))
+	crawlDate = date
+	for _, p := range products {
+		writeParquet(p)
+	}
+	closeParquet()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/shutdown"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// runRetryFailed replays the dead-letter file: product pages are parsed
+// again, and failed listing or enterprise pages are fetched again for the
+// products they link to. Recovered products are merged into the output file
+// and only the URLs that still fail stay in the dead-letter file.
+//
+//	go run . retry-failed [--dead-letter failed.jsonl] [--output output.json]
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	output := fs.String("output", "output.json", "crawl output to merge recovered products into")
+	var fetch fetchOptions
+	fetch.addFlags(fs)
+	fs.Parse(args)
+
+	entries, err := deadletter.Load(fetch.deadLetter.File)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		fmt.Printf("Nothing to retry in %s\n", fetch.deadLetter.File)
+		return
+	}
+	if err != nil {
+		fmt.Println("Error reading dead letters:", err)
+		return
+	}
+
+	products, err := loadProducts(*output)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error reading output file:", err)
+		return
+	}
+
+	proxyPool, err := fetch.setup("retry-failed")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer closeParquet()
+	defer proxyPool.LogReport(logf)
+	defer sqliteDB.LogReport(logf)
+	// The dead-letter file is rewritten at the end, not appended to
+	deadLetters = nil
+
+	var recovered []Product
+	var found []deadletter.Entry
+	fetchRef := func(ref productRef) error {
+		product, err := fetchAndParseProduct(ref.SMCEID, ref.PSID)
+		if err == nil {
+			err = product.Metadata.failure()
+		}
+		if err != nil {
+			return err
+		}
+		product.SMCEID = ref.SMCEID
+		product.PSID = ref.PSID
+		recovered = append(recovered, product)
+		upsertProduct(product)
+		writeParquet(product)
+		return nil
+	}
+
+	n, failed := deadletter.Retry(entries, func(rawURL string) error {
+		if ref, ok := parseProductURL(rawURL); ok {
+			return fetchRef(ref)
+		}
+
+		refs, err := linkedProductRefs(rawURL)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if shutdownSignal.Stopping() {
+				// the page is replayed again next time for the rest
+				return fetcherr.Fetch(fetcherr.StageFetch, rawURL, context.Canceled)
+			}
+			err := fetchRef(ref)
+			if err != nil && !errors.Is(err, fetcherr.ErrNotFound) {
+				fmt.Printf("ID smce_id=%s, ps_id=%s: %v\n", ref.SMCEID, ref.PSID, err)
+				found = append(found, deadletter.NewEntry(productURL(ref.SMCEID, ref.PSID), err, 1))
+			}
+		}
+		return nil
+	}, shutdownSignal.Stopping, logf)
+
+	products, added := mergeProducts(products, recovered)
+	if err := saveProducts(*output, products); err != nil {
+		fmt.Println("Error writing output file:", err)
+		return
+	}
+	if err := deadletter.Save(fetch.deadLetter.File, append(failed, found...)); err != nil {
+		fmt.Println("Error writing dead letters:", err)
+		return
+	}
+	fmt.Printf("Recovered %d of %d URLs: %d new and %d updated products in %s, %d URLs still failing\n",
+		n, len(entries), added, len(recovered)-added, *output, len(failed)+len(found))
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "retry-failed", Records: len(products), Output: *output}); err != nil {
+		fmt.Println("Error saving checkpoint:", err)
+	}
+}
+
+// parseProductURL reads the IDs back out of a product_detail.php URL
+func parseProductURL(rawURL string) (productRef, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !strings.HasSuffix(u.Path, "product_detail.php") {
+		return productRef{}, false
+	}
+	ref := productRef{SMCEID: u.Query().Get("smce_id"), PSID: u.Query().Get("ps_id")}
+	return ref, ref.SMCEID != "" && ref.PSID != ""
+}
+
+// linkedProductRefs fetches a listing or enterprise page and returns the
+// products it links to, following enterprise links one level down
+func linkedProductRefs(pageURL string) ([]productRef, error) {
+	doc, err := fetchDocument(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	refs := productLinks(doc, pageURL)
+
+	doc.Find("a[href*='managecontent.php']").Each(func(i int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		u, err := resolveLink(pageURL, href)
+		if err != nil || u.String() == pageURL {
+			return
+		}
+		enterprise, err := fetchDocument(u.String())
+		if err != nil {
+			fmt.Printf("Enterprise %s: %v\n", u, err)
+			return
+		}
+		refs = append(refs, productLinks(enterprise, u.String())...)
+	})
+	return refs, nil
+}
+
+// loadProducts reads an earlier output file
+func loadProducts(path string) ([]Product, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var products []Product
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// saveProducts writes products in the same layout as the crawl
+func saveProducts(path string, products []Product) error {
+	data, err := json.MarshalIndent(products, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// mergeProducts replaces products already in the output and appends the
+// rest. It returns the merged list and how many were appended.
+func mergeProducts(products, recovered []Product) ([]Product, int) {
+	index := map[productRef]int{}
+	for i, p := range products {
+		index[productRef{SMCEID: p.SMCEID, PSID: p.PSID}] = i
+	}
+
+	added := 0
+	for _, p := range recovered {
+		ref := productRef{SMCEID: p.SMCEID, PSID: p.PSID}
+		if i, ok := index[ref]; ok {
+			products[i] = p
+			continue
+		}
+		index[ref] = len(products)
+		products = append(products, p)
+		added++
+	}
+	return products, added
+}
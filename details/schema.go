@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"crawlkit/schema"
+)
+
+// recordSchema is the contract of output.json, generated from Product
+var recordSchema = schema.Generate[Product]("Product", "An SMCE product_detail.php page with the enterprise that makes it", map[string]schema.Field{
+	"phone":      schema.Phone,
+	"phone_e164": schema.PhoneE164,
+	"fax":        schema.Phone,
+	"fax_e164":   schema.PhoneE164,
+	"latitude":   schema.Latitude,
+	"longitude":  schema.Longitude,
+	"metadata.page_status": {Enum: []string{
+		string(PageFound), string(PageNotFound), string(PageError), string(PageBlocked),
+	}},
+	"metadata.source_url": {Format: "uri"},
+})
+
+// runSchema writes the JSON Schema of output.json
+//
+//	go run . schema [--output product.schema.json]
+func runSchema(args []string) {
+	message, err := schema.RunSchema(args, recordSchema, "product")
+	if err != nil {
+		fmt.Println("Error writing the JSON Schema:", err)
+		return
+	}
+	if message != "" {
+		logf("%s", message)
+	}
+}
+
+// runValidate checks an output file against the JSON Schema and reports
+// violations by JSON pointer
+//
+//	go run . validate [--input output.json] [--schema product.schema.json] [--max 20]
+func runValidate(args []string) {
+	report, err := schema.RunValidate(args, recordSchema, "output.json")
+	if err != nil {
+		fmt.Println("Error validating output file:", err)
+		os.Exit(1)
+	}
+	report.LogReport(logf)
+	if report.Invalid > 0 {
+		os.Exit(1)
+	}
+}
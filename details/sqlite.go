@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"crawlkit/sink"
+)
+
+// upsertProduct writes a saved product, its enterprise and representatives
+// to --sqlite
+func upsertProduct(p Product) {
+	latitude, _ := strconv.ParseFloat(p.Latitude, 64)
+	longitude, _ := strconv.ParseFloat(p.Longitude, 64)
+	err := sqliteDB.UpsertProduct(sink.Product{
+		SMCEID:      p.SMCEID,
+		PSID:        p.PSID,
+		ProductName: p.ProductName,
+		Price:       p.PricePerTon,
+		Enterprise: sink.Enterprise{
+			RegistrationCode: p.RegistrationCode,
+			SMCEID:           p.SMCEID,
+			Name:             p.OrganizationName,
+			Address:          p.Address,
+			Phone:            p.Phone,
+			Fax:              p.Fax,
+			Latitude:         latitude,
+			Longitude:        longitude,
+			Representatives:  p.Representatives,
+		},
+		Record: p,
+	})
+	if err != nil {
+		fmt.Printf("Error saving smce_id=%s, ps_id=%s to SQLite: %v\n", p.SMCEID, p.PSID, err)
+	}
+}
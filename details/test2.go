@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html"
 	"io"
@@ -13,42 +16,232 @@ import (
 	"strings"
 	"time"
 
+	"crawlkit/block"
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/lake"
+	"crawlkit/migrate"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/shutdown"
+	"crawlkit/sink"
+	"crawlkit/smce"
+
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/html/charset"
 )
 
 type Product struct {
-	SMCEID               string   `json:"smce_id"`
-	PSID                 string   `json:"ps_id"`
-	OrganizationName     string   `json:"organization_name"`
-	RegistrationCode     string   `json:"registration_code"`
-	Address              string   `json:"address"`
-	Phone                string   `json:"phone"`
-	Fax                  string   `json:"fax"`
-	Representatives      []string `json:"representatives"`
-	ProductName          string   `json:"product_name"`
-	Properties           string   `json:"properties"`
-	Composition          string   `json:"composition"`
-	NutritionInfo        string   `json:"nutrition_info"`
-	ProductionCapacity   string   `json:"production_capacity"`
-	PricePerTon          string   `json:"price_per_ton"`
-	Standards            string   `json:"standards"`
-	QualityAssurance     string   `json:"quality_assurance"`
-	ProductionPeriod     string   `json:"production_period"`
-	SeasonalUse          string   `json:"seasonal_use"`
-	DistributionChannels string   `json:"distribution_channels"`
-	Latitude             string   `json:"latitude"`
-	Longitude            string   `json:"longitude"`
+	SMCEID               string          `json:"smce_id"`
+	PSID                 string          `json:"ps_id"`
+	OrganizationName     string          `json:"organization_name"`
+	RegistrationCode     string          `json:"registration_code"`
+	Address              string          `json:"address"`
+	Phone                string          `json:"phone"`
+	PhoneE164            string          `json:"phone_e164,omitempty"`
+	Fax                  string          `json:"fax"`
+	FaxE164              string          `json:"fax_e164,omitempty"`
+	Representatives      []string        `json:"representatives"`
+	ProductName          string          `json:"product_name"`
+	Properties           string          `json:"properties"`
+	Composition          string          `json:"composition"`
+	NutritionInfo        string          `json:"nutrition_info"`
+	ProductionCapacity   string          `json:"production_capacity"`
+	PricePerTon          string          `json:"price_per_ton"`
+	Standards            string          `json:"standards"`
+	QualityAssurance     string          `json:"quality_assurance"`
+	ProductionPeriod     string          `json:"production_period"`
+	SeasonalUse          string          `json:"seasonal_use"`
+	DistributionChannels string          `json:"distribution_channels"`
+	Latitude             string          `json:"latitude"`
+	Longitude            string          `json:"longitude"`
+	Metadata             ProductMetadata `json:"metadata"`
+}
+
+// requestHeader is sent with every request; its user-agent is also matched
+// against robots.txt
+var requestHeader map[string]string
+
+// robotsChecker is shared by every request so each host's Crawl-delay holds
+var robotsChecker *robots.Checker
+
+// blockDetector pauses the crawl on captcha and WAF pages
+var blockDetector *block.Detector
+
+// httpClient is shared by every fetcher so they all go through --proxies
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// deadLetters keeps the URLs that failed for the retry-failed command
+var deadLetters *deadletter.Queue
+
+// shutdownSignal stops the crawl on Ctrl-C and cancels requests after the
+// grace period
+var shutdownSignal *shutdown.Signal
+
+// sqliteDB receives every saved product when --sqlite is set
+var sqliteDB *sink.DB
+
+// fetchOptions are the flags shared by every command that downloads pages
+type fetchOptions struct {
+	profile    profile.Selection
+	robots     robots.Options
+	proxy      proxy.Options
+	block      block.Options
+	deadLetter deadletter.Options
+	shutdown   shutdown.Options
+	sink       sink.Options
+	lake       lake.Options
+}
+
+func (o *fetchOptions) addFlags(fs *flag.FlagSet) {
+	o.profile.AddFlags(fs)
+	o.robots.AddFlags(fs)
+	o.proxy.AddFlags(fs)
+	o.block.AddFlags(fs)
+	o.deadLetter.AddFlags(fs)
+	o.shutdown.AddFlags(fs)
+	o.sink.AddFlags(fs)
+	o.lake.AddFlags(fs)
+}
+
+// setup prepares requestHeader, robotsChecker, httpClient, blockDetector,
+// deadLetters, shutdownSignal, sqliteDB and productLake and returns the proxy
+// pool for its end-of-run report. The caller closes shutdownSignal and
+// sqliteDB and calls closeParquet. run names the Parquet part files.
+func (o *fetchOptions) setup(run string) (*proxy.Pool, error) {
+	requestProfile, err := o.profile.Profile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid request profile: %v", err)
+	}
+	// product pages are linked from the product search results
+	requestHeader = requestProfile.Header(smce.ProductResultURL)
+	robotsChecker = robots.NewChecker(requestProfile.UserAgent, o.robots)
+	proxyPool, err := o.proxy.Pool()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy list: %v", err)
+	}
+	httpClient = proxyPool.Client(30 * time.Second)
+	robotsChecker.Client = httpClient
+	blockDetector = block.NewDetector(o.block)
+	deadLetters = deadletter.New(o.deadLetter)
+	shutdownSignal = shutdown.Listen(o.shutdown)
+	blockDetector.Sleep = shutdownSignal.Sleep
+	if sqliteDB, err = sink.Open(o.sink); err != nil {
+		shutdownSignal.Close()
+		return nil, fmt.Errorf("cannot open SQLite database: %v", err)
+	}
+	productLake = lake.Open[productRow](o.lake, "products", run)
+	crawlDate = time.Now()
+	return proxyPool, nil
+}
+
+// setHeaders adds requestHeader to req
+func setHeaders(req *http.Request) {
+	for key, value := range requestHeader {
+		req.Header.Set(key, value)
+	}
+}
+
+// logf prints a progress line the way the rest of this tool does
+func logf(format string, args ...any) {
+	fmt.Printf(format+"\n", args...)
 }
 
 func main() {
-	// Define the range of IDs to iterate over
-	// Adjust the ranges according to your needs
-	smceIDStart := 270020210001
-	smceIDEnd := 2700202100100 // Adjust the end ID as needed
+	if len(os.Args) > 1 && os.Args[1] == "retry-failed" {
+		runRetryFailed(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		runSchema(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		report, err := migrate.RunCommand(os.Args[2:], "output.json")
+		if err != nil {
+			fmt.Println("Error migrating output file:", err)
+			return
+		}
+		report.LogReport(logf)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-profile" {
+		message, err := profile.RunImport(os.Args[2:], os.Stdin)
+		if err != nil {
+			fmt.Println("Error importing profile:", err)
+			return
+		}
+		fmt.Println(message)
+		return
+	}
+
+	mode := flag.String("mode", "discover", "discover: fetch only products linked from the SMCE listings; probe: try a bounded ID range")
+
+	// Discover mode, narrowed by the usual SMCE search filters
+	var filter smce.Filter
+	filter.AddFlags(flag.CommandLine)
+	filter.AddProductFlags(flag.CommandLine, "1")
+	enterprises := flag.Bool("enterprises", true, "discover: also read the managecontent.php page of every enterprise")
+
+	// Probe mode, for products the listings miss
+	smceFrom := flag.Int64("smce-from", 270020210001, "probe: first smce_id")
+	smceTo := flag.Int64("smce-to", 270020210100, "probe: last smce_id")
+	psFrom := flag.Int("ps-from", 1, "probe: first ps_id")
+	psTo := flag.Int("ps-to", 100, "probe: last ps_id")
+	maxProbes := flag.Int("max-probes", 10000, "probe: refuse ranges with more IDs than this")
+	known := flag.String("known", "", "probe: skip IDs already saved in this output file")
+	var fetch fetchOptions
+	fetch.addFlags(flag.CommandLine)
+	flag.Parse()
+	proxyPool, err := fetch.setup(*mode)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer closeParquet()
+	defer proxyPool.LogReport(logf)
+	defer deadLetters.LogReport(logf)
+	defer sqliteDB.LogReport(logf)
+	if err := filter.Validate(); err != nil {
+		fmt.Println("Invalid filter:", err)
+		return
+	}
 
-	psIDStart := 1
-	psIDEnd := 100 // Adjust the end ID as needed
+	var refs []productRef
+	switch *mode {
+	case "discover":
+		refs = discoverProductRefs(filter, *enterprises)
+	case "probe":
+		var err error
+		refs, err = probeProductRefs(*smceFrom, *smceTo, *psFrom, *psTo, *maxProbes, *known)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	default:
+		fmt.Printf("Unknown mode %q\n", *mode)
+		return
+	}
+	if shutdownSignal.Stopping() {
+		fmt.Println("Interrupted while discovering products, output.json left as it was")
+		return
+	}
+	fmt.Printf("Fetching %d products\n", len(refs))
 
 	// Create or open the output file
 	outputFile, err := os.Create("output.json")
@@ -62,115 +255,151 @@ func main() {
 	outputFile.WriteString("[\n")
 
 	firstRecord := true
+	saved, done := 0, 0
 
-	// Loop over the IDs
-	for smceID := smceIDStart; smceID <= smceIDEnd; smceID++ {
-		for psID := psIDStart; psID <= psIDEnd; psID++ {
-			smceIDStr := strconv.Itoa(smceID)
-			psIDStr := strconv.Itoa(psID)
-
-			// Fetch and parse the product
-			product, err := fetchAndParseProduct(smceIDStr, psIDStr)
-			if err != nil {
-				fmt.Printf("ID smce_id=%s, ps_id=%s: %v\n", smceIDStr, psIDStr, err)
-				continue
+	// Loop over the IDs; Ctrl-C ends the loop and the array is still closed
+	for _, ref := range refs {
+		if shutdownSignal.Stopping() {
+			fmt.Printf("Interrupted after %d of %d products\n", done, len(refs))
+			break
+		}
+		done++
+		smceIDStr := ref.SMCEID
+		psIDStr := ref.PSID
+
+		// Fetch and parse the product
+		product, err := fetchAndParseProduct(smceIDStr, psIDStr)
+		if err != nil {
+			fmt.Printf("ID smce_id=%s, ps_id=%s: %v\n", smceIDStr, psIDStr, err)
+			deadLetters.Add(productURL(smceIDStr, psIDStr), err, 1)
+			continue
+		}
+
+		// Only real products go into the dataset
+		if product.Metadata.PageStatus != PageFound {
+			fmt.Printf("ID smce_id=%s, ps_id=%s: %s (%s)\n", smceIDStr, psIDStr, product.Metadata.PageStatus, product.Metadata.Reason)
+			if err := product.Metadata.failure(); !errors.Is(err, fetcherr.ErrNotFound) {
+				deadLetters.Add(product.Metadata.SourceURL, err, 1)
 			}
+			continue
+		}
 
-			// Assign IDs to the product struct
-			product.SMCEID = smceIDStr
-			product.PSID = psIDStr
+		// Assign IDs to the product struct
+		product.SMCEID = smceIDStr
+		product.PSID = psIDStr
 
-			// Encode the product to JSON
-			productJSON, err := json.MarshalIndent(product, "  ", "  ")
-			if err != nil {
-				fmt.Printf("Error encoding product ID smce_id=%s, ps_id=%s: %v\n", smceIDStr, psIDStr, err)
-				continue
-			}
+		// Encode the product to JSON
+		productJSON, err := json.MarshalIndent(product, "  ", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding product ID smce_id=%s, ps_id=%s: %v\n", smceIDStr, psIDStr, err)
+			continue
+		}
 
-			// Write comma if not the first record
-			if !firstRecord {
-				outputFile.WriteString(",\n")
-			} else {
-				firstRecord = false
-			}
+		// Write comma if not the first record
+		if !firstRecord {
+			outputFile.WriteString(",\n")
+		} else {
+			firstRecord = false
+		}
 
-			// Write the product JSON to the file
-			outputFile.WriteString("  ")
-			outputFile.Write(productJSON)
+		// Write the product JSON to the file
+		outputFile.WriteString("  ")
+		outputFile.Write(productJSON)
 
-			fmt.Printf("Saved product ID smce_id=%s, ps_id=%s\n", smceIDStr, psIDStr)
+		fmt.Printf("Saved product ID smce_id=%s, ps_id=%s\n", smceIDStr, psIDStr)
+		saved++
+		upsertProduct(product)
+		writeParquet(product)
 
-			// Optional: Sleep between requests to be polite
-			time.Sleep(200 * time.Millisecond)
-		}
+		// Optional: Sleep between requests to be polite
+		shutdownSignal.Sleep(200 * time.Millisecond)
 	}
 
 	// Write the closing bracket for the JSON array
 	outputFile.WriteString("\n]\n")
+	if err := outputFile.Close(); err != nil {
+		fmt.Println("Error closing output file:", err)
+		return
+	}
 
 	fmt.Println("Data extracted and saved to output.json successfully.")
-}
 
-func fetchAndParseProduct(smceID, psID string) (Product, error) {
-	var product Product
+	checkpoint := shutdown.Checkpoint{
+		Command: "details",
+		Records: saved,
+		Output:  "output.json",
+		Extra:   map[string]string{"mode": *mode, "products_done": strconv.Itoa(done), "products_total": strconv.Itoa(len(refs))},
+	}
+	if err := shutdownSignal.SaveCheckpoint(checkpoint); err != nil {
+		fmt.Println("Error saving checkpoint:", err)
+	}
+}
 
-	// Build the URL with query parameters
+// productURL builds the product_detail.php URL for one product
+func productURL(smceID, psID string) string {
 	baseURL := "https://smce2023.doae.go.th/product_detail.php"
 	params := url.Values{}
 	params.Add("smce_id", smceID)
 	params.Add("ps_id", psID)
-	fullURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
+	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
+}
+
+func fetchAndParseProduct(smceID, psID string) (Product, error) {
+	var product Product
+
+	// Build the URL with query parameters
+	fullURL := productURL(smceID, psID)
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("GET", fullURL, nil)
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", fullURL, nil)
 	if err != nil {
 		return product, fmt.Errorf("Error creating request: %v", err)
 	}
 
-	// Set necessary headers
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-	req.Header.Set("Accept-Language", "en-US,en;q=0.9,th;q=0.8")
-	req.Header.Set("Cache-Control", "no-cache")
-	req.Header.Set("Pragma", "no-cache")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64)")
+	// Set the headers of the selected request profile
+	setHeaders(req)
 
 	// Send HTTP GET request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := robotsChecker.Do(httpClient, req)
 	if err != nil {
-		return product, fmt.Errorf("Error fetching URL: %v", err)
+		return product, fetcherr.Fetch(fetcherr.StageFetch, fullURL, err)
 	}
 	defer resp.Body.Close()
 
-	// Check if status is OK
-	if resp.StatusCode != http.StatusOK {
-		return product, fmt.Errorf("Status code %d", resp.StatusCode)
+	product.Metadata = ProductMetadata{
+		HTTPStatus: resp.StatusCode,
+		SourceURL:  fullURL,
+		FetchedAt:  time.Now(),
 	}
 
 	// Use charset.NewReader to handle character encoding
 	utf8Reader, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
 	if err != nil {
-		return product, fmt.Errorf("Error creating UTF-8 reader: %v", err)
+		return product, fetcherr.Decode(fullURL, err)
 	}
 
 	// Limit the reader to prevent large responses
-	limitedReader := io.LimitReader(utf8Reader, 10*1024*1024) // 10 MB limit
-
-	// Parse the document
-	doc, err := goquery.NewDocumentFromReader(limitedReader)
+	body, err := io.ReadAll(io.LimitReader(utf8Reader, 10*1024*1024)) // 10 MB limit
 	if err != nil {
-		return product, fmt.Errorf("Error parsing HTML: %v", err)
+		return product, fetcherr.Fetch(fetcherr.StageRead, fullURL, err)
 	}
 
-	// Check for a specific element to verify if the product exists
-	if doc.Find("div.col-md-12").Length() == 0 {
-		return product, fmt.Errorf("Product not found")
+	// Parse the document
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return product, fetcherr.Decode(fullURL, err)
 	}
 
-	// Extract organization details
+	// Blocked, error and missing pages are classified before parsing
 	tables := doc.Find("table.table-striped.table-hover")
-	if tables.Length() < 2 {
-		return product, fmt.Errorf("Expected at least two tables")
+	product.Metadata.Tables = tables.Length()
+	if status, reason := classifyResponse(resp.StatusCode, string(body), tables.Length()); status != "" {
+		product.Metadata.PageStatus = status
+		product.Metadata.Reason = reason
+		if status == PageBlocked {
+			blockDetector.Blocked(reason)
+		}
+		return product, nil
 	}
 
 	organizationTable := tables.Eq(0)
@@ -190,8 +419,10 @@ func fetchAndParseProduct(smceID, psID string) (Product, error) {
 				product.Address = value
 			case "โทรศัพท์  :":
 				product.Phone = value
+				product.PhoneE164 = smce.E164(value)
 			case "โทรสาร :":
 				product.Fax = value
+				product.FaxE164 = smce.E164(value)
 			case "ผู้มีอำนาจทำการแทน :":
 				// Get HTML to preserve <br /> tags
 				htmlContent, _ := tds.Last().Html()
@@ -260,6 +491,9 @@ func fetchAndParseProduct(smceID, psID string) (Product, error) {
 	// Extract the latitude and longitude
 	extractCoordinates(doc, &product)
 
+	// Tell real products apart from the empty template served for unknown IDs
+	classifyProduct(&product)
+
 	return product, nil
 }
 
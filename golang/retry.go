@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"crawlkit/deadletter"
+	"crawlkit/shutdown"
+	"crawlkit/smce"
+)
+
+// runRetryFailed fetches the listing pages in the dead-letter file again and
+// merges their products into the output file. Only the pages that still
+// fail stay in the dead-letter file.
+//
+//	go run . retry-failed [--dead-letter failed.jsonl] [--output output.json]
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	output := fs.String("output", "output.json", "crawl output to merge recovered products into")
+	var fetch fetchOptions
+	fetch.addFlags(fs)
+	fs.Parse(args)
+
+	entries, err := deadletter.Load(fetch.deadLetter.File)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		log.Printf("Nothing to retry in %s", fetch.deadLetter.File)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read dead letters: %v", err)
+	}
+
+	enterprises, err := loadEnterprises(*output)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to read output file: %v", err)
+	}
+
+	proxyPool, err := fetch.setup(smce.ProductResultURL)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer proxyPool.LogReport(log.Printf)
+	defer shutdownSignal.Close()
+	// The dead-letter file is rewritten at the end, not appended to
+	deadLetters = nil
+
+	var recovered []CommunityEnterprise
+	n, failed := deadletter.Retry(entries, func(pageURL string) error {
+		headers := requestProfile.Header(smce.Referer(pageURL))
+		doc, err := session.FetchListing(shutdownSignal.Context(), pageURL, headers)
+		if err != nil {
+			return err
+		}
+		recovered = append(recovered, parseEnterprises(doc.Find("table.table tbody tr"))...)
+		shutdownSignal.Sleep(1 * time.Second) // Avoid overwhelming the server
+		return nil
+	}, shutdownSignal.Stopping, log.Printf)
+
+	enterprises, added := mergeEnterprises(enterprises, recovered)
+	if err := saveEnterprises(*output, enterprises); err != nil {
+		log.Fatalf("Failed to write output file: %v", err)
+	}
+	if err := deadletter.Save(fetch.deadLetter.File, failed); err != nil {
+		log.Fatalf("Failed to write dead letters: %v", err)
+	}
+	log.Printf("Recovered %d of %d pages: %d new and %d updated products in %s, %d pages still failing",
+		n, len(entries), added, len(recovered)-added, *output, len(failed))
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "retry-failed", Records: len(enterprises), Output: *output}); err != nil {
+		log.Printf("Failed to save checkpoint: %v", err)
+	}
+}
+
+// mergeEnterprises replaces products already in the output, matched by
+// enterprise, product name and image, and appends the rest. It returns the merged list
+// and how many were appended.
+func mergeEnterprises(enterprises, recovered []CommunityEnterprise) ([]CommunityEnterprise, int) {
+	key := func(e CommunityEnterprise) string { return e.EnterpriseName + "|" + e.ProductName + "|" + e.ImageURL }
+	index := map[string]int{}
+	for i, e := range enterprises {
+		index[key(e)] = i
+	}
+
+	added := 0
+	for _, e := range recovered {
+		if i, ok := index[key(e)]; ok {
+			enterprises[i] = e
+			continue
+		}
+		index[key(e)] = len(enterprises)
+		enterprises = append(enterprises, e)
+		added++
+	}
+	return enterprises, added
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"crawlkit/block"
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/migrate"
+	"crawlkit/paging"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/shutdown"
+	"crawlkit/smce"
+
 	"github.com/PuerkitoBio/goquery"
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
 )
 
 // CommunityEnterprise represents the structure for extracted data.
@@ -23,121 +33,242 @@ type CommunityEnterprise struct {
 	ImageURL        string `json:"image_url"`         // เปลี่ยนเป็น snake_case
 }
 
-func main() {
-	baseURL := "https://smce2023.doae.go.th/ProductC_Result.php?page_size=5&PAGE=%d&business_type_id=1&smce_id=&select_province=&select_region=&select_amphur=&key_word=&startPage=1&endPage=20"
-
-	headers := map[string]string{
-		"accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7",
-		"accept-language":           "en-US,en;q=0.9,th;q=0.8",
-		"cache-control":             "no-cache",
-		"cookie":                    "PHPSESSID=00qdjvaig0hbvtpksf7l2lhqj3",
-		"pragma":                    "no-cache",
-		"referer":                   "https://smce2023.doae.go.th/ProductC_Result.php?business_type_id=1&smce_id=&select_province=&select_region=&select_amphur=&key_word=",
-		"sec-ch-ua":                 `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
-		"sec-ch-ua-mobile":          "?0",
-		"sec-ch-ua-platform":        `"Windows"`,
-		"sec-fetch-dest":            "document",
-		"sec-fetch-mode":            "navigate",
-		"sec-fetch-site":            "same-origin",
-		"sec-fetch-user":            "?1",
-		"upgrade-insecure-requests": "1",
-		"user-agent":                "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+// requestProfile supplies the user-agent and headers of every request
+var requestProfile profile.Profile
+
+// session fetches the listing pages and renews PHPSESSID when it expires
+var session *smce.Session
+
+// deadLetters keeps the pages that failed for the retry-failed command
+var deadLetters *deadletter.Queue
+
+// shutdownSignal stops the crawl on Ctrl-C and cancels requests after the
+// grace period
+var shutdownSignal *shutdown.Signal
+
+// fetchOptions are the flags shared by every command that downloads pages
+type fetchOptions struct {
+	profile    profile.Selection
+	robots     robots.Options
+	proxy      proxy.Options
+	block      block.Options
+	deadLetter deadletter.Options
+	shutdown   shutdown.Options
+}
+
+func (o *fetchOptions) addFlags(fs *flag.FlagSet) {
+	o.profile.AddFlags(fs)
+	o.robots.AddFlags(fs)
+	o.proxy.AddFlags(fs)
+	o.block.AddFlags(fs)
+	o.deadLetter.AddFlags(fs)
+	o.shutdown.AddFlags(fs)
+}
+
+// setup prepares requestProfile, session, deadLetters and shutdownSignal and
+// returns the proxy pool for its end-of-run report. The session starts from
+// referer. The caller closes shutdownSignal.
+func (o *fetchOptions) setup(referer string) (*proxy.Pool, error) {
+	var err error
+	if requestProfile, err = o.profile.Profile(); err != nil {
+		return nil, fmt.Errorf("invalid request profile: %v", err)
+	}
+	proxyPool, err := o.proxy.Pool()
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy list: %v", err)
 	}
+	httpClient := proxyPool.Client(10 * time.Second)
 
-	var allEnterprises []CommunityEnterprise
+	if session, err = smce.NewSession(10 * time.Second); err != nil {
+		return nil, fmt.Errorf("failed to start session: %v", err)
+	}
+	session.Client.Transport = httpClient.Transport
+	session.Header = requestProfile.Header(referer)
+	session.Robots = robots.NewChecker(requestProfile.UserAgent, o.robots)
+	session.Robots.Client = httpClient
+	session.Block = block.NewDetector(o.block)
 
-	// Loop through pages
-	for page := 1; page <= 20; page++ {
-		url := fmt.Sprintf(baseURL, page)
-		log.Printf("Fetching page %d...\n", page)
+	deadLetters = deadletter.New(o.deadLetter)
+	shutdownSignal = shutdown.Listen(o.shutdown)
+	session.Block.Sleep = shutdownSignal.Sleep
+	return proxyPool, nil
+}
 
-		// Make the HTTP request
-		client := &http.Client{
-			Timeout: 10 * time.Second,
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import-profile" {
+		message, err := profile.RunImport(os.Args[2:], os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to import profile: %v", err)
 		}
-		req, err := http.NewRequest("GET", url, nil)
+		log.Println(message)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		report, err := migrate.RunCommand(os.Args[2:], "output.json")
 		if err != nil {
-			log.Fatalf("Failed to create request: %v", err)
+			log.Fatalf("Failed to migrate: %v", err)
 		}
+		report.LogReport(log.Printf)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retry-failed" {
+		runRetryFailed(os.Args[2:])
+		return
+	}
 
-		// Add headers to the request
-		for key, value := range headers {
-			req.Header.Set(key, value)
+	var filter smce.Filter
+	var fetch fetchOptions
+	filter.AddFlags(flag.CommandLine)
+	fetch.addFlags(flag.CommandLine)
+	filter.AddProductFlags(flag.CommandLine, "1")
+	flag.Parse()
+	if err := filter.Validate(); err != nil {
+		log.Fatalf("Invalid filter: %v", err)
+	}
+
+	proxyPool, err := fetch.setup(filter.ProductReferer())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer proxyPool.LogReport(log.Printf)
+	defer deadLetters.LogReport(log.Printf)
+	headers := requestProfile.Header(filter.ProductReferer())
+
+	var allEnterprises []CommunityEnterprise
+
+	// Ctrl-C stops after the current page and still saves everything so far
+	defer shutdownSignal.Close()
+
+	// The tracker works out the last page from the result count, or failing
+	// that follows the pager until the listing ends
+	pageSize := 5
+	tracker := paging.NewTracker("PAGE", pageSize)
+	donePage := 0
+
+	// Loop through pages
+	for page := 1; tracker.More(page); page++ {
+		if shutdownSignal.Stopping() {
+			log.Printf("Interrupted before page %d", page)
+			break
 		}
+		url := filter.ProductPageURL(pageSize, page)
+		log.Printf("Fetching page %d...\n", page)
 
-		resp, err := client.Do(req)
+		// Fetch within the session, which renews PHPSESSID when it expires
+		doc, err := session.FetchListing(shutdownSignal.Context(), url, headers)
+		if errors.Is(err, fetcherr.ErrStatus) || errors.Is(err, fetcherr.ErrBlocked) {
+			log.Printf("Error fetching page %d: %v", page, err)
+			deadLetters.Add(url, err, 1)
+			if err := tracker.Failed(page); err != nil {
+				log.Printf("Stopping: %v", err)
+				break
+			}
+			continue
+		}
+		if err != nil && shutdownSignal.Stopping() {
+			log.Printf("Interrupted while fetching page %d: %v", page, err)
+			break
+		}
 		if err != nil {
+			deadLetters.Add(url, err, 1)
 			log.Fatalf("Failed to fetch URL: %v", err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Error fetching page %d: HTTP %d", page, resp.StatusCode)
-			continue
+		if tracker.Observe(doc) {
+			log.Printf("Found %s", tracker)
 		}
 
-		// Convert from windows-874 to UTF-8
-		reader := transform.NewReader(resp.Body, charmap.Windows874.NewDecoder())
-
-		// Parse the HTML
-		doc, err := goquery.NewDocumentFromReader(reader)
-		if err != nil {
-			log.Fatalf("Failed to parse HTML: %v", err)
+		// Stop once past the end: an empty table or a repeat of an earlier page
+		rows := doc.Find("table.table tbody tr")
+		var keys []string
+		rows.Each(func(i int, row *goquery.Selection) {
+			keys = append(keys, strings.Join(strings.Fields(row.Text()), " "))
+		})
+		if err := tracker.Check(page, keys); err != nil {
+			log.Printf("Stopping at page %d: %v", page, err)
+			break
 		}
 
 		// Extract data from the page
-		doc.Find("table.table tbody tr").Each(func(i int, row *goquery.Selection) {
-			var enterprise CommunityEnterprise
-
-			// Extract image URL
-			row.Find("td img").Each(func(idx int, img *goquery.Selection) {
-				imgSrc, exists := img.Attr("src")
-				if exists {
-					enterprise.ImageURL = "https://smce2023.doae.go.th/" + strings.TrimSpace(imgSrc)
-				}
-			})
-
-			// Extract enterprise details
-			row.Find(".box-product").Each(func(idx int, item *goquery.Selection) {
-				field := strings.TrimSpace(item.Find(".pro-field").Text())
-				value := strings.TrimSpace(item.Find(".pro-disc").Text())
-
-				// Remove extra spaces and newline characters
-				value = strings.Join(strings.Fields(value), " ")
-
-				switch field {
-				case "ชื่อ":
-					enterprise.EnterpriseName = value
-				case "กลุ่มกิจการ":
-					enterprise.BusinessGroup = value
-				case "ประเภทกิจการ":
-					enterprise.BusinessType = value
-				case "ชื่อผลิตภัณฑ์/บริการ":
-					enterprise.ProductName = value
-				}
-			})
-
-			// Append data if at least the EnterpriseName or ProductName is not empty
-			if enterprise.EnterpriseName != "" || enterprise.ProductName != "" {
-				allEnterprises = append(allEnterprises, enterprise)
-			}
-		})
+		allEnterprises = append(allEnterprises, parseEnterprises(rows)...)
 
-		time.Sleep(1 * time.Second) // Avoid overwhelming the server
+		donePage = page
+
+		shutdownSignal.Sleep(1 * time.Second) // Avoid overwhelming the server
 	}
 
 	// Save all results to JSON
-	outputFile, err := os.Create("output.json")
-	if err != nil {
-		log.Fatalf("Failed to create output file: %v", err)
+	if err := saveEnterprises("output.json", allEnterprises); err != nil {
+		log.Fatalf("Failed to write output file: %v", err)
 	}
-	defer outputFile.Close()
 
-	encoder := json.NewEncoder(outputFile)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(allEnterprises); err != nil {
-		log.Fatalf("Failed to encode data to JSON: %v", err)
+	log.Println("Data extraction completed. Output saved to output.json")
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "products", Page: donePage, Records: len(allEnterprises), Output: "output.json"}); err != nil {
+		log.Printf("Failed to save checkpoint: %v", err)
 	}
+}
 
-	log.Println("Data extraction completed. Output saved to output.json")
+// parseEnterprises reads the products out of the rows of a listing page
+func parseEnterprises(rows *goquery.Selection) []CommunityEnterprise {
+	var enterprises []CommunityEnterprise
+	rows.Each(func(i int, row *goquery.Selection) {
+		var enterprise CommunityEnterprise
+
+		// Extract image URL
+		row.Find("td img").Each(func(idx int, img *goquery.Selection) {
+			imgSrc, exists := img.Attr("src")
+			if exists {
+				enterprise.ImageURL = "https://smce2023.doae.go.th/" + strings.TrimSpace(imgSrc)
+			}
+		})
+
+		// Extract enterprise details
+		row.Find(".box-product").Each(func(idx int, item *goquery.Selection) {
+			field := strings.TrimSpace(item.Find(".pro-field").Text())
+			value := strings.TrimSpace(item.Find(".pro-disc").Text())
+
+			// Remove extra spaces and newline characters
+			value = strings.Join(strings.Fields(value), " ")
+
+			switch field {
+			case "ชื่อ":
+				enterprise.EnterpriseName = value
+			case "กลุ่มกิจการ":
+				enterprise.BusinessGroup = value
+			case "ประเภทกิจการ":
+				enterprise.BusinessType = value
+			case "ชื่อผลิตภัณฑ์/บริการ":
+				enterprise.ProductName = value
+			}
+		})
+
+		// Append data if at least the EnterpriseName or ProductName is not empty
+		if enterprise.EnterpriseName != "" || enterprise.ProductName != "" {
+			enterprises = append(enterprises, enterprise)
+		}
+	})
+	return enterprises
+}
+
+// loadEnterprises reads an earlier output file
+func loadEnterprises(path string) ([]CommunityEnterprise, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var enterprises []CommunityEnterprise
+	if err := json.Unmarshal(data, &enterprises); err != nil {
+		return nil, err
+	}
+	return enterprises, nil
+}
+
+// saveEnterprises writes enterprises to path as indented JSON
+func saveEnterprises(path string, enterprises []CommunityEnterprise) error {
+	data, err := json.MarshalIndent(enterprises, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
 }
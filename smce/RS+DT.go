@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +15,21 @@ import (
 	"time"
 	"strconv"
 
+	"crawlkit/block"
+	"crawlkit/deadletter"
+	"crawlkit/diff"
+	"crawlkit/fetcherr"
+	"crawlkit/lake"
+	"crawlkit/migrate"
+	"crawlkit/paging"
+	"crawlkit/policy"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/shutdown"
+	"crawlkit/sink"
+	"crawlkit/smce"
+
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/transform"
@@ -21,6 +39,9 @@ type CommunityEnterprise struct {
 	EnterpriseName       string `json:"enterprise_name"`
 	BusinessGroup        string `json:"business_group"`
 	BusinessType         string `json:"business_type"`
+	BusinessTypeID       string `json:"business_type_id,omitempty"`
+	ProvinceCode         string `json:"province_code,omitempty"`
+	AmphurCode           string `json:"amphur_code,omitempty"`
 	ProductName          string `json:"product_name"`
 	ImageURL             string `json:"image_url"`
 	SMCEID               string `json:"smce_id"`
@@ -28,7 +49,9 @@ type CommunityEnterprise struct {
 	RegistrationCode     string `json:"registration_code"`
 	Address              string `json:"address"`
 	Phone                string `json:"phone"`
+	PhoneE164            string `json:"phone_e164,omitempty"`
 	Fax                  string `json:"fax"`
+	FaxE164              string `json:"fax_e164,omitempty"`
 	AuthorityPerson      string `json:"authority_person"`
 	Properties           string `json:"properties"`
 	Composition          string `json:"composition"`
@@ -42,157 +65,443 @@ type CommunityEnterprise struct {
 	DistributionChannels string `json:"distribution_channels"`
 }
 
+// requestProfile supplies the headers of every request
+var requestProfile profile.Profile
+
+// robotsChecker is shared by every request so each host's Crawl-delay holds
+var robotsChecker *robots.Checker
+
+// blockDetector pauses the crawl on captcha and WAF pages
+var blockDetector *block.Detector
+
+// errorPolicy decides whether a failed fetch stops, skips or retries
+var errorPolicy *policy.Policy
+
+// httpClient is shared by every fetcher so they all go through --proxies
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// shutdownSignal stops the crawl on Ctrl-C and cancels requests after the
+// grace period
+var shutdownSignal *shutdown.Signal
+
+// sqliteDB receives every parsed record when --sqlite is set
+var sqliteDB *sink.DB
+
+// fetchOptions are the flags shared by every command that crawls pages
+type fetchOptions struct {
+	profile    profile.Selection
+	robots     robots.Options
+	proxy      proxy.Options
+	block      block.Options
+	policy     policy.Options
+	deadLetter deadletter.Options
+	shutdown   shutdown.Options
+	sink       sink.Options
+	lake       lake.Options
+}
+
+func (o *fetchOptions) addFlags(fs *flag.FlagSet) {
+	o.profile.AddFlags(fs)
+	o.robots.AddFlags(fs)
+	o.proxy.AddFlags(fs)
+	o.block.AddFlags(fs)
+	o.policy.AddFlags(fs)
+	o.deadLetter.AddFlags(fs)
+	o.shutdown.AddFlags(fs)
+	o.sink.AddFlags(fs)
+	o.lake.AddFlags(fs)
+}
+
+// setup prepares requestProfile, robotsChecker, httpClient, blockDetector,
+// errorPolicy with its dead-letter queue, shutdownSignal, sqliteDB and
+// recordLake and returns the proxy pool for its end-of-run report. The
+// caller closes shutdownSignal and sqliteDB and calls closeParquet. run
+// names the Parquet part files.
+func (o *fetchOptions) setup(run string) *proxy.Pool {
+	var err error
+	if requestProfile, err = o.profile.Profile(); err != nil {
+		log.Fatalf("Invalid request profile: %v", err)
+	}
+	robotsChecker = robots.NewChecker(requestProfile.UserAgent, o.robots)
+	proxyPool, err := o.proxy.Pool()
+	if err != nil {
+		log.Fatalf("Invalid proxy list: %v", err)
+	}
+	httpClient = proxyPool.Client(10 * time.Second)
+	robotsChecker.Client = httpClient
+	blockDetector = block.NewDetector(o.block)
+	if errorPolicy, err = policy.New(o.policy); err != nil {
+		log.Fatalf("Invalid error policy: %v", err)
+	}
+	errorPolicy.DeadLetters = deadletter.New(o.deadLetter)
+	shutdownSignal = shutdown.Listen(o.shutdown)
+	blockDetector.Sleep = shutdownSignal.Sleep
+	errorPolicy.Sleep = shutdownSignal.Sleep
+	if sqliteDB, err = sink.Open(o.sink); err != nil {
+		shutdownSignal.Close()
+		log.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	recordLake = lake.Open[communityEnterpriseRow](o.lake, "community_enterprises", run)
+	crawlDate = time.Now()
+	return proxyPool
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "refdata" {
+		runRefdata(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		runSchema(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		report, err := migrate.RunCommand(os.Args[2:], "output.json")
+		if err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		report.LogReport(log.Printf)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-profile" {
+		message, err := profile.RunImport(os.Args[2:], os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to import profile: %v", err)
+		}
+		log.Println(message)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retry-failed" {
+		runRetryFailed(os.Args[2:])
+		return
+	}
+	if err := crawl(); err != nil {
+		log.Printf("Crawl stopped early: %v", err)
+		os.Exit(1)
+	}
+}
+
+// crawl runs the crawl the command line asks for. A fail-fast abort is
+// returned only after what was collected is saved and the deferred closes
+// and reports have run.
+func crawl() error {
+
+	var filter smce.Filter
+	var fetch fetchOptions
+	var diffOptions diff.Options
+	filter.AddFlags(flag.CommandLine)
+	fetch.addFlags(flag.CommandLine)
+	diffOptions.AddFlags(flag.CommandLine)
+	filter.AddProductFlags(flag.CommandLine, "1")
+	allTypes := flag.Bool("all-types", false, "crawl every business type listed on the search form, ignoring --business-type")
+	taxonomyFile := flag.String("taxonomy", "taxonomy.json", "where to write the business group/type taxonomy")
+	flag.Parse()
+	if err := filter.Validate(); err != nil {
+		log.Fatalf("Invalid filter: %v", err)
+	}
+	proxyPool := fetch.setup("crawl")
+	defer proxyPool.LogReport(log.Printf)
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer sqliteDB.LogReport(log.Printf)
+	defer closeParquet()
+	defer errorPolicy.DeadLetters.LogReport(log.Printf)
+	var tables *smce.CodeTables
+	if filter.RefData != "" {
+		var err error
+		if tables, err = smce.LoadCodeTables(filter.RefData); err != nil {
+			log.Fatalf("Failed to load code tables: %v", err)
+		}
+		log.Printf("Filter: %s", tables.Describe(filter))
+	}
+
+	// read before the crawl overwrites it
+	previous, err := diffOptions.Previous("output.json", diffSpec)
+	if err != nil {
+		log.Fatalf("Failed to read the previous output: %v", err)
+	}
+
 	var allData []CommunityEnterprise
 
-	// Fetch community enterprises
-	fetchCommunityEnterprises(&allData)
+	// Fetch community enterprises, one pass per business type in --all-types mode.
+	// A fail-fast abort or Ctrl-C still saves what was collected before exiting.
+	var crawlErr error
+	checkpoint := shutdown.Checkpoint{Command: "products", Output: "output.json"}
+	if *allTypes {
+		businessTypes, err := fetchBusinessTypes()
+		if err != nil {
+			log.Fatalf("Failed to read business types: %v", err)
+		}
+		log.Printf("Found %d business types", len(businessTypes))
+		for _, businessType := range businessTypes {
+			if shutdownSignal.Stopping() {
+				break
+			}
+			log.Printf("Crawling business type %s (%s)", businessType.Code, businessType.Label)
+			filter.BusinessType = businessType.Code
+			checkpoint.Extra = map[string]string{"business_type": businessType.Code}
+			if checkpoint.Page, crawlErr = fetchCommunityEnterprises(filter, &allData); crawlErr != nil {
+				break
+			}
+		}
+	} else {
+		checkpoint.Page, crawlErr = fetchCommunityEnterprises(filter, &allData)
+	}
+
+	if tables != nil {
+		labelRecords(tables, allData)
+	}
 
 	// Save to output.json
 	saveToJSON(allData)
+	saveTaxonomy(buildTaxonomy(allData), *taxonomyFile)
+	// records a partial crawl did not reach are not counted as removed
+	partial := crawlErr != nil || shutdownSignal.Stopping() || len(errorPolicy.Skipped()) > 0
+	if summary, err := diffOptions.Finish(previous, "output.json", diffSpec, partial); err != nil {
+		log.Printf("Error comparing with the previous output: %v", err)
+	} else {
+		summary.LogReport(log.Printf)
+	}
+	if err := errorPolicy.WriteReport(); err != nil {
+		log.Printf("Error saving skipped URL report: %v", err)
+	}
+	checkpoint.Records = len(allData)
+	if err := shutdownSignal.SaveCheckpoint(checkpoint); err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+	}
+	return crawlErr
 }
 
-func fetchCommunityEnterprises(allData *[]CommunityEnterprise) {
-	baseURL := "https://smce2023.doae.go.th/ProductC_Result.php?page_size=5&PAGE=%d&business_type_id=1&smce_id=&select_province=&select_region=&select_amphur=&key_word=&startPage=1&endPage=20"
-	headers := map[string]string{
-		"accept":        "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7",
-		"user-agent":    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
-		"cookie":        "PHPSESSID=00qdjvaig0hbvtpksf7l2lhqj3",
-		"referer":       "https://smce2023.doae.go.th/ProductC_Result.php?business_type_id=1&smce_id=&select_province=&select_region=&select_amphur=&key_word=",
+// fetchBusinessTypes reads the business types offered on the search form
+func fetchBusinessTypes() ([]smce.Option, error) {
+	doc, err := fetchDocument(smce.FormURL)
+	if err != nil {
+		return nil, err
+	}
+	businessTypes := smce.BusinessTypes(doc)
+	if len(businessTypes) == 0 {
+		return nil, fmt.Errorf("no business types found on %s", smce.FormURL)
 	}
+	return businessTypes, nil
+}
+
+// fetchCommunityEnterprises appends every listed product to allData and
+// returns the last page it finished. It only returns an error when the error
+// policy says to abort.
+func fetchCommunityEnterprises(filter smce.Filter, allData *[]CommunityEnterprise) (int, error) {
+	pageSize := 5
+	headers := requestProfile.Header(filter.ProductReferer())
+	session := newSession(headers)
+
+	// The last page is read from the result count, or failing that the
+	// pager is followed until the listing ends
+	tracker := paging.NewTracker("PAGE", pageSize)
+	donePage := 0
 
 	// Loop to fetch multiple pages
-	for page := 1; page <= 162; page++ {
-		url := fmt.Sprintf(baseURL, page)
+	for page := 1; tracker.More(page); page++ {
+		if shutdownSignal.Stopping() {
+			log.Printf("Interrupted before page %d", page)
+			return donePage, nil
+		}
+		url := filter.ProductPageURL(pageSize, page)
 		// Fetching community enterprise page
 		log.Printf("Fetching community enterprise page %d...\n", page)
 
-		// Make HTTP request
-		client := &http.Client{Timeout: 10 * time.Second}
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Fatalf("Failed to create request: %v", err)
-		}
-		for key, value := range headers {
-			req.Header.Set(key, value)
+		// Fetch within the session, which renews PHPSESSID when it expires
+		var doc *goquery.Document
+		err := errorPolicy.Do(url, func() error {
+			var err error
+			doc, err = session.FetchListing(shutdownSignal.Context(), url, headers)
+			return err
+		})
+		if errors.Is(err, policy.ErrAbort) {
+			return donePage, err
 		}
-
-		resp, err := client.Do(req)
 		if err != nil {
-			log.Fatalf("Failed to fetch URL: %v", err)
+			log.Printf("Error fetching page %d: %v", page, err)
+			if err := tracker.Failed(page); err != nil {
+				log.Printf("Stopping: %v", err)
+				return donePage, nil
+			}
+			continue
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Error fetching page %d: HTTP %d", page, resp.StatusCode)
-			continue
+		if tracker.Observe(doc) {
+			log.Printf("Found %s of community enterprises", tracker)
 		}
 
-		// Convert encoding and parse HTML
-		reader := transform.NewReader(resp.Body, charmap.Windows874.NewDecoder()) // TIS-620 -> UTF-8
-		doc, err := goquery.NewDocumentFromReader(reader)
-		if err != nil {
-			log.Fatalf("Failed to parse HTML: %v", err)
+		// Stop once past the end: an empty table or a repeat of an earlier page
+		rows := doc.Find("table.table tbody tr")
+		if err := tracker.Check(page, rowKeys(rows)); err != nil {
+			log.Printf("Stopping at page %d: %v", page, err)
+			break
 		}
 
 		// Extract community enterprise data
-		doc.Find("table.table tbody tr").Each(func(i int, row *goquery.Selection) {
-			var enterprise CommunityEnterprise
-
-			// Extract image URL
-			row.Find("td img").Each(func(idx int, img *goquery.Selection) {
-				imgSrc, exists := img.Attr("src")
-				if exists {
-					enterprise.ImageURL = "https://smce2023.doae.go.th/" + strings.TrimSpace(imgSrc)
-				}
-			})
-
-			// Extract enterprise details
-			row.Find(".box-product").Each(func(idx int, item *goquery.Selection) {
-				field := strings.TrimSpace(item.Find(".pro-field").Text())
-				value := strings.TrimSpace(item.Find(".pro-disc").Text())
-
-				// Clean up value and store the information
-				value = strings.Join(strings.Fields(value), " ")
-
-				// Handle each field
-				switch field {
-				case "ชื่อ":
-					enterprise.EnterpriseName = value
-				case "กลุ่มกิจการ":
-					enterprise.BusinessGroup = value
-				case "ประเภทกิจการ":
-					enterprise.BusinessType = value
-				case "ชื่อผลิตภัณฑ์/บริการ":
-					enterprise.ProductName = value
-				}
-			})
-
-			// Extract smce_id and ps_id
-			row.Find("a").Each(func(idx int, a *goquery.Selection) {
-				href, exists := a.Attr("href")
-				if exists && strings.Contains(href, "product_detail.php") {
-					// Extract smce_id and ps_id from URL
-					re := regexp.MustCompile(`smce_id=(\d+)&ps_id=(\d+)`)
-					matches := re.FindStringSubmatch(href)
-					if len(matches) == 3 {
-						enterprise.SMCEID = matches[1]
-						enterprise.PSID = matches[2]
-					}
+		var abortErr error
+		rows.Each(func(i int, row *goquery.Selection) {
+			enterprise := parseListingRow(row, filter.BusinessType)
+
+			// Fetch product details using smce_id and ps_id; a skipped page
+			// keeps the listing fields and is named in the skipped report and
+			// the dead-letter file.
+			// After Ctrl-C the rest of the page keeps only the listing fields.
+			if enterprise.SMCEID != "" && enterprise.PSID != "" && abortErr == nil && !shutdownSignal.Stopping() {
+				detailURL := productDetailURL(enterprise.SMCEID, enterprise.PSID)
+				err := errorPolicy.Do(detailURL, func() error {
+					return fetchProductDetails(detailURL, headers, &enterprise)
+				})
+				if errors.Is(err, policy.ErrAbort) {
+					abortErr = err
+				} else if err != nil {
+					log.Printf("Error fetching product details: %v", err)
 				}
-			})
-
-			// Fetch product details using smce_id and ps_id
-			if enterprise.SMCEID != "" && enterprise.PSID != "" {
-				fetchProductDetails(&enterprise)
 			}
 
 			// Add to allData array
 			*allData = append(*allData, enterprise)
+			upsertEnterprise(enterprise)
+			writeParquet(enterprise)
 		})
 
-		time.Sleep(1 * time.Second)
+		if abortErr != nil {
+			return donePage, abortErr
+		}
+		if !shutdownSignal.Stopping() {
+			donePage = page
+		}
+
+		shutdownSignal.Sleep(1 * time.Second)
 	}
+	return donePage, nil
 }
 
-func fetchProductDetails(enterprise *CommunityEnterprise) {
-	// Build URL for product details page
-	baseURL := "https://smce2023.doae.go.th/product_detail.php"
+// newSession starts an SMCE session sending header, going through the shared
+// robots checker, proxies and block detector
+func newSession(header map[string]string) *smce.Session {
+	session, err := smce.NewSession(10 * time.Second)
+	if err != nil {
+		log.Fatalf("Failed to start session: %v", err)
+	}
+	session.Header = header
+	session.Robots = robotsChecker
+	session.Client.Transport = httpClient.Transport
+	session.Block = blockDetector
+	return session
+}
+
+// parseListingRow reads the listing fields and the smce_id and ps_id of one
+// product listing row
+func parseListingRow(row *goquery.Selection, businessTypeID string) CommunityEnterprise {
+	enterprise := CommunityEnterprise{BusinessTypeID: businessTypeID}
+
+	// Extract image URL
+	row.Find("td img").Each(func(idx int, img *goquery.Selection) {
+		imgSrc, exists := img.Attr("src")
+		if exists {
+			enterprise.ImageURL = "https://smce2023.doae.go.th/" + strings.TrimSpace(imgSrc)
+		}
+	})
+
+	// Extract enterprise details
+	row.Find(".box-product").Each(func(idx int, item *goquery.Selection) {
+		field := strings.TrimSpace(item.Find(".pro-field").Text())
+		value := strings.TrimSpace(item.Find(".pro-disc").Text())
+
+		// Clean up value and store the information
+		value = strings.Join(strings.Fields(value), " ")
+
+		// Handle each field
+		switch field {
+		case "ชื่อ":
+			enterprise.EnterpriseName = value
+		case "กลุ่มกิจการ":
+			enterprise.BusinessGroup = value
+		case "ประเภทกิจการ":
+			enterprise.BusinessType = value
+		case "ชื่อผลิตภัณฑ์/บริการ":
+			enterprise.ProductName = value
+		}
+	})
+
+	// Extract smce_id and ps_id
+	row.Find("a").Each(func(idx int, a *goquery.Selection) {
+		href, exists := a.Attr("href")
+		if exists && strings.Contains(href, "product_detail.php") {
+			// Extract smce_id and ps_id from URL
+			re := regexp.MustCompile(`smce_id=(\d+)&ps_id=(\d+)`)
+			matches := re.FindStringSubmatch(href)
+			if len(matches) == 3 {
+				enterprise.SMCEID = matches[1]
+				enterprise.PSID = matches[2]
+			}
+		}
+	})
+	return enterprise
+}
+
+// productDetailURL builds the URL of a product details page
+func productDetailURL(smceID, psID string) string {
 	params := url.Values{}
-	params.Add("smce_id", enterprise.SMCEID)
-	params.Add("ps_id", enterprise.PSID)
-	fullURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
+	params.Add("smce_id", smceID)
+	params.Add("ps_id", psID)
+	return fmt.Sprintf("%s?%s", "https://smce2023.doae.go.th/product_detail.php", params.Encode())
+}
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+// fetchProductDetails fills in enterprise from its product details page,
+// sending the same headers as the listing it was linked from
+func fetchProductDetails(fullURL string, header map[string]string, enterprise *CommunityEnterprise) error {
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", fullURL, nil)
 	if err != nil {
-		log.Printf("Error creating request for product details: %v", err)
-		return
+		return fmt.Errorf("creating request: %v", err)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := robotsChecker.Do(httpClient, req)
 	if err != nil {
-		log.Printf("Error fetching product details: %v", err)
-		return
+		return fetcherr.Fetch(fetcherr.StageFetch, fullURL, err)
 	}
 	defer resp.Body.Close()
 
+	body, err := blockDetector.ReadBody(fullURL, resp)
+	if err != nil {
+		return fetcherr.Fetch(fetcherr.StageRead, fullURL, err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error fetching product details: HTTP %d", resp.StatusCode)
-		return
+		return fetcherr.Status(fullURL, resp.StatusCode)
 	}
 
 	// Parse the product details page (convert from Windows-874 encoding to UTF-8)
-	reader := transform.NewReader(resp.Body, charmap.Windows874.NewDecoder()) // TIS-620 -> UTF-8
+	reader := transform.NewReader(bytes.NewReader(body), charmap.Windows874.NewDecoder()) // TIS-620 -> UTF-8
 	doc, err := goquery.NewDocumentFromReader(reader)
 	if err != nil {
-		log.Printf("Error parsing product details HTML: %v", err)
-		return
+		return fetcherr.Decode(fullURL, err)
 	}
 
 	// Extract additional product details
-	doc.Find("table tr").Each(func(i int, row *goquery.Selection) {
+	rows := doc.Find("table tr")
+	if rows.Length() == 0 {
+		if err := blockDetector.Unexpected(fullURL, len(body)); err != nil {
+			return fetcherr.Fetch(fetcherr.StageRead, fullURL, err)
+		}
+		return fetcherr.Structure(fullURL, "no product detail table")
+	}
+	rows.Each(func(i int, row *goquery.Selection) {
 		// Extract only the value without the prefix (e.g., "รหัสผลิตภัณฑ์")
 		value := extractDataFromRow(row)
 
@@ -213,8 +522,10 @@ func fetchProductDetails(enterprise *CommunityEnterprise) {
 			enterprise.Address = cleanField(value)
 		case strings.Contains(value, "โทรศัพท์"):
 			enterprise.Phone = cleanField(value)
+			enterprise.PhoneE164 = smce.E164(enterprise.Phone)
 		case strings.Contains(value, "โทรสาร"):
 			enterprise.Fax = cleanField(value)
+			enterprise.FaxE164 = smce.E164(enterprise.Fax)
 		case strings.Contains(value, "ผู้มีอำนาจทำการแทน"):  
 			enterprise.AuthorityPerson = cleanField(value) 
 		case strings.Contains(value, "คุณสมบัติ"):
@@ -237,6 +548,7 @@ func fetchProductDetails(enterprise *CommunityEnterprise) {
 			enterprise.DistributionChannels = cleanField(value)
 		}
 	})
+	return nil
 }
 
 // Helper function to clean field values
@@ -262,6 +574,17 @@ func cleanField(value string) string {
 	return value
 }
 
+// rowKeys returns the text of each listing row, used to spot repeated pages
+func rowKeys(rows *goquery.Selection) []string {
+	var keys []string
+	rows.Each(func(i int, row *goquery.Selection) {
+		if key := extractDataFromRow(row); key != "" {
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
 // Extract value from table row
 func extractDataFromRow(row *goquery.Selection) string {
 	// Clean and return the value from the row
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+
+	"crawlkit/diff"
+)
+
+// diffSpec matches the products of two runs by their SMCE IDs
+var diffSpec = diff.Spec{
+	Noun: "products",
+	Key:  []string{"smce_id", "ps_id"},
+}
+
+// runDiff compares two snapshots and writes the added, removed and changed
+// records as NDJSON
+//
+//	go run . diff --before last-week.json [--after output.json] [--output diff.ndjson]
+func runDiff(args []string) {
+	summary, err := diff.RunCommand(args, diffSpec, "output.json")
+	if err != nil {
+		log.Fatalf("Failed to compare snapshots: %v", err)
+	}
+	summary.LogReport(log.Printf)
+}
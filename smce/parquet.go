@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"crawlkit/export"
+	"crawlkit/lake"
+)
+
+// communityEnterpriseRow is the Parquet schema of the community_enterprises
+// dataset, one row per listed product
+type communityEnterpriseRow struct {
+	EnterpriseName       string              `parquet:"enterprise_name,optional"`
+	BusinessGroup        string              `parquet:"business_group,optional"`
+	BusinessType         string              `parquet:"business_type,optional"`
+	BusinessTypeID       string              `parquet:"business_type_id,optional"`
+	ProvinceCode         string              `parquet:"province_code,optional"`
+	AmphurCode           string              `parquet:"amphur_code,optional"`
+	Province             string              `parquet:"province,optional"`
+	ProductName          string              `parquet:"product_name,optional"`
+	ImageURL             string              `parquet:"image_url,optional"`
+	SMCEID               string              `parquet:"smce_id"`
+	PSID                 string              `parquet:"ps_id"`
+	RegistrationCode     string              `parquet:"registration_code,optional"`
+	Address              string              `parquet:"address,optional"`
+	Phone                string              `parquet:"phone,optional"`
+	Fax                  string              `parquet:"fax,optional"`
+	Representatives      []representativeRow `parquet:"representatives,list"`
+	Properties           string              `parquet:"properties,optional"`
+	Composition          string              `parquet:"composition,optional"`
+	NutritionInfo        string              `parquet:"nutrition_info,optional"`
+	ProductionPeriod     string              `parquet:"production_period,optional"`
+	ProductionCapacity   string              `parquet:"production_capacity,optional"`
+	Price                float64             `parquet:"price,optional"`
+	Standards            string              `parquet:"standards,optional"`
+	QualityAssurance     string              `parquet:"quality_assurance,optional"`
+	SeasonalUse          string              `parquet:"seasonal_use,optional"`
+	DistributionChannels string              `parquet:"distribution_channels,optional"`
+}
+
+type representativeRow struct {
+	Name string `parquet:"name"`
+}
+
+func newCommunityEnterpriseRow(e CommunityEnterprise) communityEnterpriseRow {
+	row := communityEnterpriseRow{
+		EnterpriseName:       e.EnterpriseName,
+		BusinessGroup:        e.BusinessGroup,
+		BusinessType:         e.BusinessType,
+		BusinessTypeID:       e.BusinessTypeID,
+		ProvinceCode:         e.ProvinceCode,
+		AmphurCode:           e.AmphurCode,
+		Province:             lake.Province(e.Address),
+		ProductName:          e.ProductName,
+		ImageURL:             e.ImageURL,
+		SMCEID:               e.SMCEID,
+		PSID:                 e.PSID,
+		RegistrationCode:     e.RegistrationCode,
+		Address:              e.Address,
+		Phone:                e.Phone,
+		Fax:                  e.Fax,
+		Properties:           e.Properties,
+		Composition:          e.Composition,
+		NutritionInfo:        e.NutritionInfo,
+		ProductionPeriod:     e.ProductionPeriod,
+		ProductionCapacity:   e.ProductionCapacity,
+		Price:                lake.Float(e.Price),
+		Standards:            e.Standards,
+		QualityAssurance:     e.QualityAssurance,
+		SeasonalUse:          e.SeasonalUse,
+		DistributionChannels: e.DistributionChannels,
+	}
+	if e.AuthorityPerson != "" {
+		row.Representatives = []representativeRow{{Name: e.AuthorityPerson}}
+	}
+	return row
+}
+
+// recordLake receives every parsed record when --parquet-dir is set
+var recordLake *lake.Dataset[communityEnterpriseRow]
+
+// crawlDate partitions the Parquet output; it is when the run started
+var crawlDate time.Time
+
+// writeParquet adds a parsed record to the community_enterprises dataset
+func writeParquet(e CommunityEnterprise) {
+	row := newCommunityEnterpriseRow(e)
+	if err := recordLake.Write(crawlDate, row.Province, row); err != nil {
+		log.Printf("Error writing Parquet: %v", err)
+	}
+}
+
+// closeParquet finishes the Parquet files and reports how many rows they hold
+func closeParquet() {
+	if err := recordLake.Close(); err != nil {
+		log.Printf("Error closing Parquet files: %v", err)
+	}
+	recordLake.LogReport(log.Printf)
+}
+
+// exportParquet writes an earlier crawl as Parquet, by crawl date and province
+func exportParquet(opts export.Options, records []CommunityEnterprise) {
+	date, err := lake.CrawlDate(opts.CrawlDate, opts.Input)
+	if err != nil {
+		log.Fatalf("Invalid crawl date: %v", err)
+	}
+	recordLake = lake.Open[communityEnterpriseRow](lake.Options{Dir: opts.Dir()}, "community_enterprises", lake.InputRun(opts.Input))
+	crawlDate = date
+	for _, e := range records {
+		writeParquet(e)
+	}
+	closeParquet()
+}
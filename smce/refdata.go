@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"crawlkit/block"
+	"crawlkit/fetcherr"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/smce"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// runRefdata crawls the region, province, amphur and business type dropdowns
+// into code tables the crawlers load with --refdata.
+//
+//	go run . refdata [--output refdata.json]
+func runRefdata(args []string) {
+	fs := flag.NewFlagSet("refdata", flag.ExitOnError)
+	output := fs.String("output", "refdata.json", "where to write the code tables")
+	var selection profile.Selection
+	selection.AddFlags(fs)
+	var robotsOptions robots.Options
+	robotsOptions.AddFlags(fs)
+	var proxyOptions proxy.Options
+	proxyOptions.AddFlags(fs)
+	var blockOptions block.Options
+	blockOptions.AddFlags(fs)
+	fs.Parse(args)
+	var err error
+	if requestProfile, err = selection.Profile(); err != nil {
+		log.Fatalf("Invalid request profile: %v", err)
+	}
+	robotsChecker = robots.NewChecker(requestProfile.UserAgent, robotsOptions)
+	proxyPool, err := proxyOptions.Pool()
+	if err != nil {
+		log.Fatalf("Invalid proxy list: %v", err)
+	}
+	defer proxyPool.LogReport(log.Printf)
+	httpClient = proxyPool.Client(10 * time.Second)
+	robotsChecker.Client = httpClient
+	blockDetector = block.NewDetector(blockOptions)
+
+	tables, err := smce.FetchCodeTables(func(pageURL string) (*goquery.Document, error) {
+		doc, err := fetchDocument(pageURL)
+		time.Sleep(500 * time.Millisecond)
+		return doc, err
+	}, log.Printf)
+	if err != nil {
+		log.Fatalf("Failed to fetch code tables: %v", err)
+	}
+	if err := tables.Save(*output); err != nil {
+		log.Fatalf("Error saving code tables: %v", err)
+	}
+
+	amphurs := 0
+	for _, province := range tables.Provinces {
+		amphurs += len(province.Amphurs)
+	}
+	log.Printf("Code tables %s saved to %s: %d regions, %d provinces, %d amphurs, %d business types",
+		tables.Version, *output, len(tables.Regions), len(tables.Provinces), amphurs, len(tables.BusinessTypes))
+}
+
+// labelRecords fills in the province and amphur codes from each address
+func labelRecords(tables *smce.CodeTables, data []CommunityEnterprise) {
+	labelled := 0
+	for i := range data {
+		province, amphur, ok := tables.Locate(data[i].Address)
+		if !ok {
+			continue
+		}
+		data[i].ProvinceCode = province.Code
+		data[i].AmphurCode = amphur.Code
+		labelled++
+	}
+	log.Printf("Labelled %d of %d records with code tables %s", labelled, len(data), tables.Version)
+}
+
+// fetchDocument GETs a page of the search form and decodes it from TIS-620
+func fetchDocument(pageURL string) (*goquery.Document, error) {
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range requestProfile.Header(smce.FormURL) {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := robotsChecker.Do(httpClient, req)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageFetch, pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := blockDetector.ReadBody(pageURL, resp)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageRead, pageURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetcherr.Status(pageURL, resp.StatusCode)
+	}
+
+	reader := transform.NewReader(bytes.NewReader(body), charmap.Windows874.NewDecoder()) // TIS-620 -> UTF-8
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, fetcherr.Decode(pageURL, err)
+	}
+	return doc, nil
+}
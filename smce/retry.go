@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/shutdown"
+	"crawlkit/smce"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// runRetryFailed replays the dead-letter file: failed product detail pages
+// are fetched again for the records they belong to, and failed listing
+// pages are parsed again with the details of every product on them.
+// Recovered records are merged into the output file and only the URLs that
+// still fail stay in the dead-letter file.
+//
+//	go run . retry-failed [--dead-letter failed.jsonl] [--output output.json]
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	output := fs.String("output", "output.json", "crawl output to merge recovered records into")
+	var fetch fetchOptions
+	fetch.addFlags(fs)
+	fs.Parse(args)
+
+	entries, err := deadletter.Load(fetch.deadLetter.File)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		log.Printf("Nothing to retry in %s", fetch.deadLetter.File)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read dead letters: %v", err)
+	}
+
+	records, err := loadEnterprises(*output)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to read output file: %v", err)
+	}
+
+	proxyPool := fetch.setup("retry-failed")
+	defer proxyPool.LogReport(log.Printf)
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer sqliteDB.LogReport(log.Printf)
+	defer closeParquet()
+	// Every URL gets one attempt and the dead-letter file is rewritten at
+	// the end, so the policy neither retries nor records
+	errorPolicy = nil
+
+	index := enterpriseIndex(records)
+	var session *smce.Session // started for the first listing page
+	var recovered []CommunityEnterprise
+	var found []deadletter.Entry
+	keep := func(e CommunityEnterprise) {
+		recovered = append(recovered, e)
+		upsertEnterprise(e)
+		writeParquet(e)
+	}
+
+	n, failed := deadletter.Retry(entries, func(rawURL string) error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		header := requestProfile.Header(smce.Referer(rawURL))
+
+		if strings.HasSuffix(u.Path, "product_detail.php") {
+			// fill in the record the page belongs to, or start one
+			enterprise := CommunityEnterprise{SMCEID: u.Query().Get("smce_id"), PSID: u.Query().Get("ps_id")}
+			if i, ok := index[enterpriseKey(enterprise)]; ok {
+				enterprise = records[i]
+			}
+			if err := fetchProductDetails(rawURL, requestProfile.Header(smce.ProductResultURL), &enterprise); err != nil {
+				return err
+			}
+			keep(enterprise)
+			return nil
+		}
+
+		// a listing page: every product on it, with its details
+		if session == nil {
+			session = newSession(requestProfile.Header(smce.ProductResultURL))
+		}
+		doc, err := session.FetchListing(shutdownSignal.Context(), rawURL, header)
+		if err != nil {
+			return err
+		}
+		businessTypeID := u.Query().Get("business_type_id")
+		var listed []CommunityEnterprise
+		doc.Find("table.table tbody tr").Each(func(i int, row *goquery.Selection) {
+			listed = append(listed, parseListingRow(row, businessTypeID))
+		})
+		for _, enterprise := range listed {
+			if shutdownSignal.Stopping() {
+				// the page is replayed again next time for the rest
+				return fetcherr.Fetch(fetcherr.StageFetch, rawURL, context.Canceled)
+			}
+			if enterprise.SMCEID != "" && enterprise.PSID != "" {
+				detailURL := productDetailURL(enterprise.SMCEID, enterprise.PSID)
+				err := fetchProductDetails(detailURL, header, &enterprise)
+				if err != nil && !errors.Is(err, fetcherr.ErrNotFound) {
+					log.Printf("Error fetching product details: %v", err)
+					found = append(found, deadletter.NewEntry(detailURL, err, 1))
+				}
+			}
+			keep(enterprise)
+		}
+		shutdownSignal.Sleep(1 * time.Second)
+		return nil
+	}, shutdownSignal.Stopping, log.Printf)
+
+	records, added := mergeEnterprises(records, recovered)
+	if err := saveEnterprises(*output, records); err != nil {
+		log.Fatalf("Failed to write output file: %v", err)
+	}
+	if err := deadletter.Save(fetch.deadLetter.File, append(failed, found...)); err != nil {
+		log.Fatalf("Failed to write dead letters: %v", err)
+	}
+	log.Printf("Recovered %d of %d URLs: %d new and %d updated records in %s, %d URLs still failing",
+		n, len(entries), added, len(recovered)-added, *output, len(failed)+len(found))
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "retry-failed", Records: len(records), Output: *output}); err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+	}
+}
+
+// enterpriseKey identifies a record by its product; records without IDs
+// have no key
+func enterpriseKey(e CommunityEnterprise) string {
+	if e.SMCEID == "" || e.PSID == "" {
+		return ""
+	}
+	return e.SMCEID + "|" + e.PSID
+}
+
+// enterpriseIndex maps the key of each record to its position
+func enterpriseIndex(records []CommunityEnterprise) map[string]int {
+	index := map[string]int{}
+	for i, e := range records {
+		if key := enterpriseKey(e); key != "" {
+			index[key] = i
+		}
+	}
+	return index
+}
+
+// mergeEnterprises replaces records already in the output and appends the
+// rest. It returns the merged list and how many were appended.
+func mergeEnterprises(records, recovered []CommunityEnterprise) ([]CommunityEnterprise, int) {
+	index := enterpriseIndex(records)
+	added := 0
+	for _, e := range recovered {
+		key := enterpriseKey(e)
+		if i, ok := index[key]; ok && key != "" {
+			records[i] = e
+			continue
+		}
+		if key != "" {
+			index[key] = len(records)
+		}
+		records = append(records, e)
+		added++
+	}
+	return records, added
+}
+
+// loadEnterprises reads an earlier output file
+func loadEnterprises(path string) ([]CommunityEnterprise, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []CommunityEnterprise
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// saveEnterprises writes records in the same layout as saveToJSON
+func saveEnterprises(path string, records []CommunityEnterprise) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"crawlkit/schema"
+)
+
+// recordSchema is the contract of output.json, generated from
+// CommunityEnterprise
+var recordSchema = schema.Generate[CommunityEnterprise]("CommunityEnterprise", "An SMCE listing row with the details of its product", map[string]schema.Field{
+	"phone":      schema.Phone,
+	"phone_e164": schema.PhoneE164,
+	"fax":        schema.Phone,
+	"fax_e164":   schema.PhoneE164,
+})
+
+// runSchema writes the JSON Schema of output.json
+//
+//	go run . schema [--output community_enterprise.schema.json]
+func runSchema(args []string) {
+	message, err := schema.RunSchema(args, recordSchema, "community_enterprise")
+	if err != nil {
+		log.Fatalf("Failed to write the JSON Schema: %v", err)
+	}
+	if message != "" {
+		log.Println(message)
+	}
+}
+
+// runValidate checks an output file against the JSON Schema and reports
+// violations by JSON pointer
+//
+//	go run . validate [--input output.json] [--schema community_enterprise.schema.json] [--max 20]
+func runValidate(args []string) {
+	report, err := schema.RunValidate(args, recordSchema, "output.json")
+	if err != nil {
+		log.Fatalf("Failed to validate: %v", err)
+	}
+	report.LogReport(log.Printf)
+	if report.Invalid > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+
+	"crawlkit/sink"
+)
+
+// upsertEnterprise writes a parsed listing row, with its product details, to
+// --sqlite
+func upsertEnterprise(e CommunityEnterprise) {
+	var representatives []string
+	if e.AuthorityPerson != "" {
+		representatives = []string{e.AuthorityPerson}
+	}
+	err := sqliteDB.UpsertProduct(sink.Product{
+		SMCEID:      e.SMCEID,
+		PSID:        e.PSID,
+		ProductName: e.ProductName,
+		Price:       e.Price,
+		Enterprise: sink.Enterprise{
+			RegistrationCode: e.RegistrationCode,
+			SMCEID:           e.SMCEID,
+			Name:             e.EnterpriseName,
+			BusinessGroup:    e.BusinessGroup,
+			BusinessType:     e.BusinessType,
+			Address:          e.Address,
+			Phone:            e.Phone,
+			Fax:              e.Fax,
+			Representatives:  representatives,
+		},
+		Record: e,
+	})
+	if err != nil {
+		log.Printf("Error saving smce_id=%s, ps_id=%s to SQLite: %v", e.SMCEID, e.PSID, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// unspecified is used for products listed without a group or type
+const unspecified = "(unspecified)"
+
+// Taxonomy is the business group -> business type -> product tree of a crawl
+type Taxonomy struct {
+	GeneratedAt    time.Time       `json:"generated_at"`
+	TotalProducts  int             `json:"total_products"`
+	BusinessGroups []BusinessGroup `json:"business_groups"`
+}
+
+// BusinessGroup is one กลุ่มกิจการ with the types listed under it
+type BusinessGroup struct {
+	Name          string         `json:"name"`
+	ProductCount  int            `json:"product_count"`
+	BusinessTypes []BusinessType `json:"business_types"`
+}
+
+// BusinessType is one ประเภทกิจการ with its products
+type BusinessType struct {
+	ID              string            `json:"id,omitempty"`
+	Name            string            `json:"name"`
+	ProductCount    int               `json:"product_count"`
+	EnterpriseCount int               `json:"enterprise_count"`
+	Products        []TaxonomyProduct `json:"products"`
+}
+
+// TaxonomyProduct is the product reference kept in the tree
+type TaxonomyProduct struct {
+	SMCEID         string `json:"smce_id"`
+	PSID           string `json:"ps_id"`
+	ProductName    string `json:"product_name"`
+	EnterpriseName string `json:"enterprise_name"`
+}
+
+// buildTaxonomy groups crawled products by business group and type. A product
+// seen under several filters is counted once.
+func buildTaxonomy(data []CommunityEnterprise) Taxonomy {
+	groups := map[string]map[string]*BusinessType{}
+	enterprises := map[*BusinessType]map[string]bool{}
+	seen := map[string]bool{}
+
+	taxonomy := Taxonomy{GeneratedAt: time.Now()}
+	for _, enterprise := range data {
+		key := enterprise.SMCEID + "/" + enterprise.PSID
+		if enterprise.SMCEID != "" && seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		groupName := orUnspecified(enterprise.BusinessGroup)
+		typeName := orUnspecified(enterprise.BusinessType)
+		if groups[groupName] == nil {
+			groups[groupName] = map[string]*BusinessType{}
+		}
+		businessType := groups[groupName][typeName]
+		if businessType == nil {
+			businessType = &BusinessType{Name: typeName}
+			groups[groupName][typeName] = businessType
+			enterprises[businessType] = map[string]bool{}
+		}
+		if businessType.ID == "" {
+			businessType.ID = enterprise.BusinessTypeID
+		}
+
+		businessType.Products = append(businessType.Products, TaxonomyProduct{
+			SMCEID:         enterprise.SMCEID,
+			PSID:           enterprise.PSID,
+			ProductName:    enterprise.ProductName,
+			EnterpriseName: enterprise.EnterpriseName,
+		})
+		businessType.ProductCount++
+		enterprises[businessType][enterprise.SMCEID+enterprise.EnterpriseName] = true
+		taxonomy.TotalProducts++
+	}
+
+	for groupName, types := range groups {
+		group := BusinessGroup{Name: groupName}
+		for _, businessType := range types {
+			businessType.EnterpriseCount = len(enterprises[businessType])
+			group.ProductCount += businessType.ProductCount
+			group.BusinessTypes = append(group.BusinessTypes, *businessType)
+		}
+		sort.Slice(group.BusinessTypes, func(i, j int) bool {
+			return group.BusinessTypes[i].Name < group.BusinessTypes[j].Name
+		})
+		taxonomy.BusinessGroups = append(taxonomy.BusinessGroups, group)
+	}
+	sort.Slice(taxonomy.BusinessGroups, func(i, j int) bool {
+		return taxonomy.BusinessGroups[i].Name < taxonomy.BusinessGroups[j].Name
+	})
+	return taxonomy
+}
+
+func orUnspecified(name string) string {
+	if name == "" {
+		return unspecified
+	}
+	return name
+}
+
+// saveTaxonomy writes the taxonomy tree next to output.json
+func saveTaxonomy(taxonomy Taxonomy, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Error creating file: %v", err)
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(taxonomy); err != nil {
+		log.Fatalf("Error saving taxonomy to JSON: %v", err)
+	}
+
+	log.Printf("Taxonomy of %d products in %d business groups saved to %s", taxonomy.TotalProducts, len(taxonomy.BusinessGroups), path)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+
+	"crawlkit/diff"
+)
+
+// diffSpec matches the enterprises of two runs by registration code; serial
+// is only their position in the listing
+var diffSpec = diff.Spec{
+	Noun:   "enterprises",
+	Key:    []string{"registration_code"},
+	Ignore: []string{"serial"},
+}
+
+// runDiff compares two snapshots and writes the added, removed and changed
+// records as NDJSON
+//
+//	go run . diff --before last-week.json [--after output.json] [--output diff.ndjson]
+func runDiff(args []string) {
+	summary, err := diff.RunCommand(args, diffSpec, "output.json")
+	if err != nil {
+		log.Fatalf("Failed to compare snapshots: %v", err)
+	}
+	summary.LogReport(log.Printf)
+}
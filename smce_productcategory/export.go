@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"crawlkit/export"
+)
+
+// runExport writes an earlier crawl as CSV or XLSX for Excel, or as Parquet
+//
+//	go run . export [--format csv|xlsx|parquet] [--input output.json] [--output export.csv]
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var opts export.Options
+	opts.AddFlags(fs, "output.json")
+	fs.Parse(args)
+
+	enterprises, err := export.ReadRecords[Enterprise](opts.Input)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", opts.Input, err)
+	}
+	if opts.Format == "parquet" {
+		exportParquet(opts, enterprises)
+		return
+	}
+
+	spec := export.Spec{Name: "enterprises", Key: []string{"registration_code"}, Numbers: []string{"serial"}}
+	path, err := export.Write(opts, spec, enterprises)
+	if err != nil {
+		log.Fatalf("Failed to export: %v", err)
+	}
+	log.Printf("Exported %d enterprises to %s", len(enterprises), path)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"crawlkit/export"
+	"crawlkit/lake"
+)
+
+// enterpriseRow is the Parquet schema of the enterprises dataset
+type enterpriseRow struct {
+	Serial           int64               `parquet:"serial,optional"`
+	OrganizationName string              `parquet:"organization_name,optional"`
+	RegistrationCode string              `parquet:"registration_code,optional"`
+	Address          string              `parquet:"address,optional"`
+	Province         string              `parquet:"province,optional"`
+	Phone            string              `parquet:"phone,optional"`
+	Representatives  []representativeRow `parquet:"representatives,list"`
+	Latitude         float64             `parquet:"latitude,optional"`
+	Longitude        float64             `parquet:"longitude,optional"`
+}
+
+type representativeRow struct {
+	Name string `parquet:"name"`
+}
+
+func newEnterpriseRow(e Enterprise) enterpriseRow {
+	serial, _ := strconv.ParseInt(e.Serial, 10, 64)
+	row := enterpriseRow{
+		Serial:           serial,
+		OrganizationName: e.OrganizationName,
+		RegistrationCode: e.RegistrationCode,
+		Address:          e.Address,
+		Province:         lake.Province(e.Address),
+		Phone:            e.Phone,
+		Latitude:         e.Latitude,
+		Longitude:        e.Longitude,
+	}
+	if e.Representatives != "" {
+		row.Representatives = []representativeRow{{Name: e.Representatives}}
+	}
+	return row
+}
+
+// enterpriseLake receives every parsed enterprise when --parquet-dir is set
+var enterpriseLake *lake.Dataset[enterpriseRow]
+
+// crawlDate partitions the Parquet output; it is when the run started
+var crawlDate time.Time
+
+// writeParquet adds a parsed enterprise to the enterprises dataset
+func writeParquet(e Enterprise) {
+	row := newEnterpriseRow(e)
+	if err := enterpriseLake.Write(crawlDate, row.Province, row); err != nil {
+		log.Printf("Error writing Parquet: %v", err)
+	}
+}
+
+// closeParquet finishes the Parquet files and reports how many rows they hold
+func closeParquet() {
+	if err := enterpriseLake.Close(); err != nil {
+		log.Printf("Error closing Parquet files: %v", err)
+	}
+	enterpriseLake.LogReport(log.Printf)
+}
+
+// exportParquet writes an earlier crawl as Parquet, by crawl date and province
+func exportParquet(opts export.Options, enterprises []Enterprise) {
+	date, err := lake.CrawlDate(opts.CrawlDate, opts.Input)
+	if err != nil {
+		log.Fatalf("Invalid crawl date: %v", err)
+	}
+	enterpriseLake = lake.Open[enterpriseRow](lake.Options{Dir: opts.Dir()}, "enterprises", lake.InputRun(opts.Input))
+	crawlDate = date
+	for _, e := range enterprises {
+		writeParquet(e)
+	}
+	closeParquet()
+}
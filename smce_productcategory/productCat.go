@@ -1,13 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"crawlkit/block"
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/lake"
+	"crawlkit/migrate"
+	"crawlkit/paging"
+	"crawlkit/policy"
+	"crawlkit/profile"
+	"crawlkit/proxy"
+	"crawlkit/robots"
+	"crawlkit/shutdown"
+	"crawlkit/sink"
+	"crawlkit/smce"
 
 	"github.com/PuerkitoBio/goquery"
 	"golang.org/x/net/html/charset"
@@ -22,62 +41,236 @@ type Enterprise struct {
 	RegistrationCode string  `json:"registration_code,omitempty"`
 	Address          string  `json:"address,omitempty"`
 	Phone            string  `json:"phone,omitempty"`
+	PhoneE164        string  `json:"phone_e164,omitempty"`
 	Representatives  string  `json:"representatives,omitempty"`
 	Latitude         float64 `json:"latitude,omitempty"`  // เพิ่มฟิลด์ Latitude
 	Longitude        float64 `json:"longitude,omitempty"` // เพิ่มฟิลด์ Longitude
 }
 
+// requestHeader is sent with every request; its user-agent is also matched
+// against robots.txt
+var requestHeader map[string]string
+
+// robotsChecker is shared by every request so each host's Crawl-delay holds
+var robotsChecker *robots.Checker
+
+// blockDetector pauses the crawl on captcha and WAF pages
+var blockDetector *block.Detector
+
+// errorPolicy decides whether a failed fetch stops, skips or retries
+var errorPolicy *policy.Policy
+
+// shutdownSignal stops the crawl on Ctrl-C and cancels requests after the
+// grace period
+var shutdownSignal *shutdown.Signal
+
+// sqliteDB receives every parsed enterprise when --sqlite is set
+var sqliteDB *sink.DB
+
+// fetchOptions are the flags shared by every command that downloads pages
+type fetchOptions struct {
+	profile    profile.Selection
+	robots     robots.Options
+	proxy      proxy.Options
+	block      block.Options
+	policy     policy.Options
+	deadLetter deadletter.Options
+	shutdown   shutdown.Options
+	sink       sink.Options
+	lake       lake.Options
+}
+
+func (o *fetchOptions) addFlags(fs *flag.FlagSet) {
+	o.profile.AddFlags(fs)
+	o.robots.AddFlags(fs)
+	o.proxy.AddFlags(fs)
+	o.block.AddFlags(fs)
+	o.policy.AddFlags(fs)
+	o.deadLetter.AddFlags(fs)
+	o.shutdown.AddFlags(fs)
+	o.sink.AddFlags(fs)
+	o.lake.AddFlags(fs)
+}
+
+// setup prepares requestHeader for pages linked from referer, robotsChecker,
+// blockDetector, errorPolicy with its dead-letter queue, shutdownSignal,
+// sqliteDB and enterpriseLake and returns the proxy pool for its end-of-run
+// report. The caller closes shutdownSignal and sqliteDB and calls
+// closeParquet. run names the Parquet part files.
+func (o *fetchOptions) setup(referer, run string) *proxy.Pool {
+	requestProfile, err := o.profile.Profile()
+	if err != nil {
+		log.Fatalf("Invalid request profile: %v", err)
+	}
+	requestHeader = requestProfile.Header(referer)
+	robotsChecker = robots.NewChecker(requestProfile.UserAgent, o.robots)
+	proxyPool, err := o.proxy.Pool()
+	if err != nil {
+		log.Fatalf("Invalid proxy list: %v", err)
+	}
+	robotsChecker.Client = proxyPool.Client(30 * time.Second)
+	blockDetector = block.NewDetector(o.block)
+	if errorPolicy, err = policy.New(o.policy); err != nil {
+		log.Fatalf("Invalid error policy: %v", err)
+	}
+	errorPolicy.DeadLetters = deadletter.New(o.deadLetter)
+	shutdownSignal = shutdown.Listen(o.shutdown)
+	blockDetector.Sleep = shutdownSignal.Sleep
+	errorPolicy.Sleep = shutdownSignal.Sleep
+	if sqliteDB, err = sink.Open(o.sink); err != nil {
+		shutdownSignal.Close()
+		log.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	enterpriseLake = lake.Open[enterpriseRow](o.lake, "enterprises", run)
+	crawlDate = time.Now()
+	return proxyPool
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		runSchema(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		runValidate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		report, err := migrate.RunCommand(os.Args[2:], "output.json")
+		if err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		report.LogReport(log.Printf)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import-profile" {
+		message, err := profile.RunImport(os.Args[2:], os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to import profile: %v", err)
+		}
+		log.Println(message)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "retry-failed" {
+		runRetryFailed(os.Args[2:])
+		return
+	}
+	if err := crawl(); err != nil {
+		log.Printf("Crawl stopped early: %v", err)
+		os.Exit(1)
+	}
+}
+
+// crawl runs the crawl the command line asks for. A fail-fast abort is
+// returned only after what was collected is saved and the deferred closes
+// and reports have run.
+func crawl() error {
+
+	// ตัวกรองการค้นหา เช่น --province 10 --keyword ข้าว
+	var filter smce.Filter
+	filter.AddFlags(flag.CommandLine)
+	var fetch fetchOptions
+	fetch.addFlags(flag.CommandLine)
+	flag.Parse()
+	if err := filter.Validate(); err != nil {
+		log.Fatalf("Invalid filter: %v", err)
+	}
+	proxyPool := fetch.setup(filter.CategoryReferer(), "crawl")
+	defer proxyPool.LogReport(log.Printf)
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer sqliteDB.LogReport(log.Printf)
+	defer closeParquet()
+	defer errorPolicy.DeadLetters.LogReport(log.Printf)
+
 	// ตั้งค่า page size และ จำนวนหน้า
-	pageSize := 10       // จำนวนข้อมูลต่อหน้า
-	totalPages := 50     // จำนวนหน้าที่ต้องการดึงข้อมูล
+	pageSize := 10 // จำนวนข้อมูลต่อหน้า
+	// อ่านจำนวนหน้าจากจำนวนรายการที่ค้นพบ ถ้าไม่มีก็ไล่ตาม pager จนหมดรายการ
+	tracker := paging.NewTracker("PAGE", pageSize)
 	var allEnterprises []Enterprise
 
+	// fail-fast หรือ Ctrl-C หยุดดึงข้อมูล แต่ยังบันทึกสิ่งที่ได้มาแล้วก่อนออก
+	var crawlErr error
+	donePage := 0
+
 	// ดึงข้อมูลจากทุกหน้า
-	for pageNumber := 1; pageNumber <= totalPages; pageNumber++ {
+	for pageNumber := 1; tracker.More(pageNumber); pageNumber++ {
+		if shutdownSignal.Stopping() {
+			log.Printf("Interrupted before page %d", pageNumber)
+			break
+		}
+
 		// URL สำหรับดึงข้อมูลจากแต่ละหน้า
-		url := fmt.Sprintf("https://smce2023.doae.go.th/ProductCategory/SmceCategory.php?page_size=%d&PAGE=%d&province_id=&region_id=&amphur_id=&key_word=&startPage=%d&endPage=%d", pageSize, pageNumber, pageSize*(pageNumber-1)+1, pageSize*pageNumber)
+		url := filter.CategoryPageURL(pageSize, pageNumber)
 
 		// ดึงข้อมูลจากหน้าแรกที่มีการจัด Pagination
-		resp, err := http.Get(url)
+		var doc *goquery.Document
+		err := errorPolicy.Do(url, func() error {
+			var err error
+			doc, err = fetchListingPage(url)
+			return err
+		})
+		if errors.Is(err, policy.ErrAbort) {
+			crawlErr = err
+			break
+		}
 		if err != nil {
-			log.Fatalf("Failed to fetch URL: %v", err)
+			log.Printf("Skipping page %d: %v", pageNumber, err)
+			if err := tracker.Failed(pageNumber); err != nil {
+				log.Printf("Stopping: %v", err)
+				break
+			}
+			continue
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			log.Fatalf("Failed to fetch page: HTTP %d", resp.StatusCode)
+		if tracker.Observe(doc) {
+			log.Printf("Found %s", tracker)
 		}
 
-		// แปลงจาก windows-874 เป็น UTF-8
-		reader := transform.NewReader(resp.Body, charmap.Windows874.NewDecoder())
-
-		// แปลง HTML
-		doc, err := goquery.NewDocumentFromReader(reader)
-		if err != nil {
-			log.Fatalf("Failed to parse HTML: %v", err)
+		// หยุดเมื่อเลยหน้าสุดท้าย: ตารางว่างหรือได้แถวซ้ำกับหน้าที่เคยดึงแล้ว
+		rows := doc.Find("table.table tbody tr")
+		var keys []string
+		rows.Each(func(i int, row *goquery.Selection) {
+			keys = append(keys, strings.TrimSpace(row.Text()))
+		})
+		if err := tracker.Check(pageNumber, keys); err != nil {
+			log.Printf("Stopping at page %d: %v", pageNumber, err)
+			break
 		}
 
 		// ดึงข้อมูลจากแต่ละแถวในตาราง
-		doc.Find("table.table tbody tr").Each(func(i int, row *goquery.Selection) {
-			// ดึง serial จากคอลัมน์แรก
-			serial := extractSerial(row)
-
-			// ค้นหาลิงก์ที่มี smce_id
-			row.Find("td a").Each(func(j int, link *goquery.Selection) {
-				smceID, exists := link.Attr("href")
-				if exists && strings.Contains(smceID, "smce_id=") {
-					// ดึง smce_id จาก href
-					smceID = extractSmceID(smceID)
-
-					// Log ข้อมูลหน้า
-					log.Printf("Fetching page %d, smce_id: %s, serial: %s", pageNumber, smceID, serial)
-
-					// ดึงข้อมูลจาก smce_id นี้
-					fetchEnterpriseData(smceID, serial, &allEnterprises)
-				}
+		for _, link := range enterpriseLinks(rows) {
+			// Log ข้อมูลหน้า
+			log.Printf("Fetching page %d, smce_id: %s, serial: %s", pageNumber, link.smceID, link.serial)
+
+			// ดึงข้อมูลจาก smce_id นี้ (หลัง Ctrl-C ไม่เริ่ม request ใหม่)
+			if crawlErr != nil || shutdownSignal.Stopping() {
+				continue
+			}
+			pageURL := enterpriseURL(link.smceID)
+			err := errorPolicy.Do(pageURL, func() error {
+				return fetchEnterpriseData(pageURL, link.serial, &allEnterprises)
 			})
-		})
+			if errors.Is(err, policy.ErrAbort) {
+				crawlErr = err
+			} else if err != nil {
+				log.Printf("Error fetching enterprise: %v", err)
+			}
+		}
+		if crawlErr != nil {
+			break
+		}
+		if !shutdownSignal.Stopping() {
+			donePage = pageNumber
+		}
 
 		// แสดงผลใน terminal ว่ากำลังดึงข้อมูลจากหน้าไหน
 		log.Printf("Fetching page %d...", pageNumber)
@@ -87,7 +280,7 @@ func main() {
 	outputFile, err := os.Create("output.json")
 	if err != nil {
 		fmt.Println("Error creating output file:", err)
-		return
+		return nil
 	}
 	defer outputFile.Close()
 
@@ -95,10 +288,87 @@ func main() {
 	encoder.SetIndent("", "  ")
 	if err := encoder.Encode(allEnterprises); err != nil {
 		fmt.Println("Error encoding JSON:", err)
-		return
+		return nil
 	}
 
 	fmt.Println("Data extraction completed. Output saved to output.json")
+
+	if err := errorPolicy.WriteReport(); err != nil {
+		log.Printf("Error saving skipped URL report: %v", err)
+	}
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "enterprises", Page: donePage, Records: len(allEnterprises), Output: "output.json"}); err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+	}
+	return crawlErr
+}
+
+// get downloads pageURL with the headers of the request profile
+func get(pageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(shutdownSignal.Context(), "GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range requestHeader {
+		req.Header.Set(key, value)
+	}
+	return robotsChecker.Do(robotsChecker.Client, req)
+}
+
+// fetchListingPage downloads one page of the enterprise listing
+func fetchListingPage(url string) (*goquery.Document, error) {
+	resp, err := get(url)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageFetch, url, err)
+	}
+	defer resp.Body.Close()
+
+	// หน้า captcha / WAF มักตอบ 200 จึงต้องตรวจเนื้อหาก่อน
+	body, err := blockDetector.ReadBody(url, resp)
+	if err != nil {
+		return nil, fetcherr.Fetch(fetcherr.StageRead, url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetcherr.Status(url, resp.StatusCode)
+	}
+
+	// แปลงจาก windows-874 เป็น UTF-8
+	reader := transform.NewReader(bytes.NewReader(body), charmap.Windows874.NewDecoder())
+
+	// แปลง HTML
+	doc, err := goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		return nil, fetcherr.Decode(url, err)
+	}
+	return doc, nil
+}
+
+// enterpriseLink is an enterprise a listing row links to
+type enterpriseLink struct {
+	serial string
+	smceID string
+}
+
+// enterpriseLinks returns the enterprises the rows of a listing page link to
+func enterpriseLinks(rows *goquery.Selection) []enterpriseLink {
+	var links []enterpriseLink
+	rows.Each(func(i int, row *goquery.Selection) {
+		// ดึง serial จากคอลัมน์แรก
+		serial := extractSerial(row)
+
+		// ค้นหาลิงก์ที่มี smce_id
+		row.Find("td a").Each(func(j int, link *goquery.Selection) {
+			href, exists := link.Attr("href")
+			if exists && strings.Contains(href, "smce_id=") {
+				links = append(links, enterpriseLink{serial: serial, smceID: extractSmceID(href)})
+			}
+		})
+	})
+	return links
+}
+
+// enterpriseURL builds the managecontent.php URL of an enterprise
+func enterpriseURL(smceID string) string {
+	return fmt.Sprintf("https://smce2023.doae.go.th/ProductCategory/managecontent.php?smce_id=%s", smceID)
 }
 
 // Function to extract smce_id from the href attribute
@@ -118,41 +388,42 @@ func extractSerial(row *goquery.Selection) string {
 }
 
 // Fetch data for each smce_id
-func fetchEnterpriseData(smceID string, serial string, allEnterprises *[]Enterprise) {
-	enterpriseURL := fmt.Sprintf("https://smce2023.doae.go.th/ProductCategory/managecontent.php?smce_id=%s", smceID)
-
+func fetchEnterpriseData(enterpriseURL string, serial string, allEnterprises *[]Enterprise) error {
 	// ดึงข้อมูลจากหน้าเดียว
-	resp, err := http.Get(enterpriseURL)
+	resp, err := get(enterpriseURL)
 	if err != nil {
-		log.Printf("Error fetching URL: %v", err)
-		return
+		return fetcherr.Fetch(fetcherr.StageFetch, enterpriseURL, err)
 	}
 	defer resp.Body.Close()
 
+	body, err := blockDetector.ReadBody(enterpriseURL, resp)
+	if err != nil {
+		return fetcherr.Fetch(fetcherr.StageRead, enterpriseURL, err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error fetching page: HTTP %d", resp.StatusCode)
-		return
+		return fetcherr.Status(enterpriseURL, resp.StatusCode)
 	}
 
 	// แปลงเอกสาร HTML จาก charset
-	utf8Reader, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	utf8Reader, err := charset.NewReader(bytes.NewReader(body), resp.Header.Get("Content-Type"))
 	if err != nil {
-		log.Printf("Error creating UTF-8 reader: %v", err)
-		return
+		return fetcherr.Decode(enterpriseURL, err)
 	}
 
 	// แปลง HTML
 	doc, err := goquery.NewDocumentFromReader(utf8Reader)
 	if err != nil {
-		log.Printf("Error parsing HTML: %v", err)
-		return
+		return fetcherr.Decode(enterpriseURL, err)
 	}
 
 	// ค้นหาตารางในเอกสาร HTML
 	tables := doc.Find("table.table-striped.table-hover")
 	if tables.Length() < 1 {
-		log.Printf("Error: Expected at least one table")
-		return
+		// หน้าที่เล็กผิดปกติและไม่มีตารางอาจเป็นหน้าบล็อก
+		if err := blockDetector.Unexpected(enterpriseURL, len(body)); err != nil {
+			return fetcherr.Fetch(fetcherr.StageRead, enterpriseURL, err)
+		}
+		return fetcherr.Structure(enterpriseURL, "expected at least one table")
 	}
 
 	// ดึงข้อมูลจากแถวของตาราง
@@ -177,6 +448,7 @@ func fetchEnterpriseData(smceID string, serial string, allEnterprises *[]Enterpr
 				enterprise.Address = value
 			case "โทรศัพท์  :":
 				enterprise.Phone = value
+				enterprise.PhoneE164 = smce.E164(value)
 			case "ผู้มีอำนาจทำการแทน :":
 				// การดึง HTML content ของผู้มีอำนาจทำการแทน
 				htmlContent, _ := tds.Last().Html()
@@ -204,6 +476,31 @@ func fetchEnterpriseData(smceID string, serial string, allEnterprises *[]Enterpr
 
 	// เพิ่มข้อมูลที่ดึงได้ลงใน allEnterprises
 	*allEnterprises = append(*allEnterprises, enterprise)
+	upsertEnterprise(extractSmceID(enterpriseURL), enterprise)
+	writeParquet(enterprise)
+	return nil
+}
+
+// upsertEnterprise writes a parsed enterprise to --sqlite, keyed on its
+// registration code
+func upsertEnterprise(smceID string, enterprise Enterprise) {
+	var representatives []string
+	if enterprise.Representatives != "" {
+		representatives = []string{enterprise.Representatives}
+	}
+	err := sqliteDB.UpsertEnterprise(sink.Enterprise{
+		RegistrationCode: enterprise.RegistrationCode,
+		SMCEID:           smceID,
+		Name:             enterprise.OrganizationName,
+		Address:          enterprise.Address,
+		Phone:            enterprise.Phone,
+		Latitude:         enterprise.Latitude,
+		Longitude:        enterprise.Longitude,
+		Representatives:  representatives,
+	})
+	if err != nil {
+		log.Printf("Error saving enterprise %s to SQLite: %v", smceID, err)
+	}
 }
 
 // stripTags removes HTML tags from a string
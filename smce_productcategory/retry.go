@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"crawlkit/deadletter"
+	"crawlkit/fetcherr"
+	"crawlkit/shutdown"
+	"crawlkit/smce"
+)
+
+// runRetryFailed replays the dead-letter file: failed enterprise pages are
+// fetched again, and failed listing pages are fetched again for the
+// enterprises they link to. Recovered enterprises are merged into the output
+// file and only the URLs that still fail stay in the dead-letter file.
+//
+//	go run . retry-failed [--dead-letter failed.jsonl] [--output output.json]
+func runRetryFailed(args []string) {
+	fs := flag.NewFlagSet("retry-failed", flag.ExitOnError)
+	output := fs.String("output", "output.json", "crawl output to merge recovered enterprises into")
+	var fetch fetchOptions
+	fetch.addFlags(fs)
+	fs.Parse(args)
+
+	entries, err := deadletter.Load(fetch.deadLetter.File)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		log.Printf("Nothing to retry in %s", fetch.deadLetter.File)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to read dead letters: %v", err)
+	}
+
+	enterprises, err := loadEnterprises(*output)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to read output file: %v", err)
+	}
+
+	proxyPool := fetch.setup(smce.CategoryURL, "retry-failed")
+	defer proxyPool.LogReport(log.Printf)
+	defer shutdownSignal.Close()
+	defer sqliteDB.Close()
+	defer sqliteDB.LogReport(log.Printf)
+	defer closeParquet()
+	// ทุก URL ดึงใหม่ครั้งเดียว และไฟล์ dead-letter ถูกเขียนใหม่ทั้งไฟล์ตอนจบ
+	errorPolicy = nil
+
+	var recovered []Enterprise
+	var found []deadletter.Entry
+	n, failed := deadletter.Retry(entries, func(rawURL string) error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(u.Path, "managecontent.php") {
+			// the serial is kept from the output when merging
+			return fetchEnterpriseData(rawURL, "", &recovered)
+		}
+
+		// หน้ารายการที่เคยล้มเหลว: ดึงทุกวิสาหกิจที่หน้านั้นลิงก์ไป
+		doc, err := fetchListingPage(rawURL)
+		if err != nil {
+			return err
+		}
+		for _, link := range enterpriseLinks(doc.Find("table.table tbody tr")) {
+			if shutdownSignal.Stopping() {
+				// the page is replayed again next time for the rest
+				return fetcherr.Fetch(fetcherr.StageFetch, rawURL, context.Canceled)
+			}
+			pageURL := enterpriseURL(link.smceID)
+			err := fetchEnterpriseData(pageURL, link.serial, &recovered)
+			if err != nil && !errors.Is(err, fetcherr.ErrNotFound) {
+				log.Printf("Error fetching enterprise: %v", err)
+				found = append(found, deadletter.NewEntry(pageURL, err, 1))
+			}
+		}
+		shutdownSignal.Sleep(1 * time.Second)
+		return nil
+	}, shutdownSignal.Stopping, log.Printf)
+
+	enterprises, added := mergeEnterprises(enterprises, recovered)
+	if err := saveEnterprises(*output, enterprises); err != nil {
+		log.Fatalf("Failed to write output file: %v", err)
+	}
+	if err := deadletter.Save(fetch.deadLetter.File, append(failed, found...)); err != nil {
+		log.Fatalf("Failed to write dead letters: %v", err)
+	}
+	log.Printf("Recovered %d of %d URLs: %d new and %d updated enterprises in %s, %d URLs still failing",
+		n, len(entries), added, len(recovered)-added, *output, len(failed)+len(found))
+
+	if err := shutdownSignal.SaveCheckpoint(shutdown.Checkpoint{Command: "retry-failed", Records: len(enterprises), Output: *output}); err != nil {
+		log.Printf("Error saving checkpoint: %v", err)
+	}
+}
+
+// mergeEnterprises replaces enterprises already in the output, matched by
+// registration code, and appends the rest. A replaced enterprise keeps its
+// serial when the retry did not come from a listing row. It returns the
+// merged list and how many were appended.
+func mergeEnterprises(enterprises, recovered []Enterprise) ([]Enterprise, int) {
+	index := map[string]int{}
+	for i, e := range enterprises {
+		if e.RegistrationCode != "" {
+			index[e.RegistrationCode] = i
+		}
+	}
+
+	added := 0
+	for _, e := range recovered {
+		if i, ok := index[e.RegistrationCode]; ok && e.RegistrationCode != "" {
+			if e.Serial == "" {
+				e.Serial = enterprises[i].Serial
+			}
+			enterprises[i] = e
+			continue
+		}
+		if e.RegistrationCode != "" {
+			index[e.RegistrationCode] = len(enterprises)
+		}
+		enterprises = append(enterprises, e)
+		added++
+	}
+	return enterprises, added
+}
+
+// loadEnterprises reads an earlier output file
+func loadEnterprises(path string) ([]Enterprise, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var enterprises []Enterprise
+	if err := json.Unmarshal(data, &enterprises); err != nil {
+		return nil, err
+	}
+	return enterprises, nil
+}
+
+// saveEnterprises writes enterprises in the same layout as the crawl
+func saveEnterprises(path string, enterprises []Enterprise) error {
+	data, err := json.MarshalIndent(enterprises, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"crawlkit/schema"
+)
+
+// recordSchema is the contract of output.json, generated from Enterprise
+var recordSchema = schema.Generate[Enterprise]("Enterprise", "An SMCE community enterprise page", map[string]schema.Field{
+	"phone":      schema.Phone,
+	"phone_e164": schema.PhoneE164,
+	"latitude":   schema.Latitude,
+	"longitude":  schema.Longitude,
+})
+
+// runSchema writes the JSON Schema of output.json
+//
+//	go run . schema [--output enterprise.schema.json]
+func runSchema(args []string) {
+	message, err := schema.RunSchema(args, recordSchema, "enterprise")
+	if err != nil {
+		log.Fatalf("Failed to write the JSON Schema: %v", err)
+	}
+	if message != "" {
+		log.Println(message)
+	}
+}
+
+// runValidate checks an output file against the JSON Schema and reports
+// violations by JSON pointer
+//
+//	go run . validate [--input output.json] [--schema enterprise.schema.json] [--max 20]
+func runValidate(args []string) {
+	report, err := schema.RunValidate(args, recordSchema, "output.json")
+	if err != nil {
+		log.Fatalf("Failed to validate: %v", err)
+	}
+	report.LogReport(log.Printf)
+	if report.Invalid > 0 {
+		os.Exit(1)
+	}
+}